@@ -0,0 +1,219 @@
+package opennetzteil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/rumpelsepp/helpers"
+)
+
+// actor identifies the originator of a command.
+type actor struct {
+	Name    string
+	Address string
+}
+
+func requestActor(r *http.Request) actor {
+	return actor{Name: clientIdentity(r), Address: r.RemoteAddr}
+}
+
+// AuditEntry records a state-changing command. Old is the value read
+// from the device before the command. If the device cannot report it,
+// e.g. the setpoints of drivers without SetpointReader, Old is the
+// value last set via the API; it is null if unknown.
+type AuditEntry struct {
+	Time    time.Time   `json:"time"`
+	Client  string      `json:"client"`
+	Address string      `json:"address"`
+	Device  string      `json:"device"`
+	Channel int         `json:"channel"`
+	Command string      `json:"command"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// AuditLog keeps the latest entries in memory and appends
+// all entries to a file as JSON lines.
+type AuditLog struct {
+	mutex   sync.Mutex
+	size    int
+	entries []AuditEntry
+	file    *os.File
+	logger  *penlogger.Logger
+}
+
+// NewAuditLog creates an audit log keeping size entries in memory.
+// If path is empty, the entries are not written to a file.
+func NewAuditLog(path string, size int, logger *penlogger.Logger) (*AuditLog, error) {
+	l := &AuditLog{size: size, logger: logger}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+// Record appends e to the log.
+func (l *AuditLog) Record(e AuditEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+	if l.file == nil {
+		return
+	}
+	bs, err := json.Marshal(e)
+	if err != nil {
+		l.logger.LogErrorf("audit: %s", err)
+		return
+	}
+	if _, err := l.file.Write(append(bs, '\n')); err != nil {
+		l.logger.LogErrorf("audit: %s", err)
+	}
+}
+
+// auditFilter selects audit entries; zero values match everything.
+type auditFilter struct {
+	Device  string
+	Channel *int
+	Client  string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	access  func(device string) bool
+}
+
+func (f *auditFilter) match(e *AuditEntry) bool {
+	switch {
+	case f.Device != "" && e.Device != f.Device:
+		return false
+	case f.Channel != nil && e.Channel != *f.Channel:
+		return false
+	case f.Client != "" && e.Client != f.Client:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.access != nil && !f.access(e.Device):
+		return false
+	}
+	return true
+}
+
+// Entries returns the matching entries, oldest first. If a limit
+// is set, the latest entries are returned.
+func (l *AuditLog) Entries(f auditFilter) []AuditEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	res := []AuditEntry{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(res) >= f.Limit {
+			break
+		}
+		if f.match(&l.entries[i]) {
+			res = append(res, l.entries[i])
+		}
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+func (l *AuditLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func (d *Device) audit(a actor, cmd string, channel int, old, new interface{}, err error) {
+	if d.auditLog == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:    time.Now(),
+		Client:  a.Name,
+		Address: a.Address,
+		Device:  d.label(),
+		Channel: channel,
+		Command: cmd,
+		Old:     old,
+		New:     new,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	d.auditLog.Record(e)
+}
+
+func parseAuditFilter(r *http.Request) (auditFilter, error) {
+	var (
+		f     auditFilter
+		query = r.URL.Query()
+		err   error
+	)
+	f.Device = query.Get("device")
+	f.Client = query.Get("client")
+	if v := query.Get("channel"); v != "" {
+		ch, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid channel: %s", v)
+		}
+		f.Channel = &ch
+	}
+	if v := query.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+	return f, nil
+}
+
+func (s *HTTPServer) getAudit(w http.ResponseWriter, r *http.Request) {
+	if s.Devices.Audit == nil {
+		sendError(w, ErrNotImplemented)
+		return
+	}
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Device != "" {
+		if d, _, err := s.Devices.Lookup(f.Device); err == nil {
+			f.Device = d.label()
+		}
+	}
+	if p := principalFromContext(r.Context()); p != nil {
+		f.access = func(device string) bool {
+			return p.mayAccess(s.deviceAliases(device)...)
+		}
+	}
+	helpers.SendJSON(w, s.Devices.Audit.Entries(f))
+}
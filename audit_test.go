@@ -0,0 +1,111 @@
+package opennetzteil_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/rumpelsepp/opennetzteil"
+	"github.com/rumpelsepp/opennetzteil/devices/dummy"
+)
+
+// panelDevice reports its setpoints; they are
+// changed on the front panel by the test.
+type panelDevice struct {
+	dummy.DummyDevice
+	voltage float64
+}
+
+func (d *panelDevice) GetVoltageSetpoint(channel int) (float64, error) {
+	return d.voltage, nil
+}
+
+func (d *panelDevice) GetCurrentSetpoint(channel int) (float64, error) {
+	return 1, nil
+}
+
+func TestAuditOldValue(t *testing.T) {
+	var (
+		logger   = penlogger.NewLogger("test", io.Discard)
+		registry = opennetzteil.NewRegistry(logger)
+		panel    = &panelDevice{voltage: 7.5}
+	)
+	audit, err := opennetzteil.NewAuditLog("", 100, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Audit = audit
+	registry.HealthInterval = time.Hour
+	registry.Update([]*opennetzteil.Device{
+		{Key: "panel", Name: "panel", Open: func() (opennetzteil.Netzteil, error) { return panel, nil }},
+		{Key: "dummy", Name: "dummy", Open: func() (opennetzteil.Netzteil, error) { return &dummy.DummyDevice{}, nil }},
+	})
+	defer registry.Close()
+
+	api := opennetzteil.HTTPServer{
+		ReqLog:  io.Discard,
+		Logger:  logger,
+		Devices: registry,
+	}
+	srv := httptest.NewServer(api.CreateHandler())
+	defer srv.Close()
+
+	// putVoltage sets the voltage via the API and
+	// returns the old value of the audit entry.
+	putVoltage := func(device, voltage string) interface{} {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/_netzteil/api/devices/"+device+"/channels/1/voltage", strings.NewReader(voltage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT voltage: %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(srv.URL + "/_netzteil/api/audit?device=" + device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var entries []opennetzteil.AuditEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			t.Fatal("no audit entry")
+		}
+		e := entries[len(entries)-1]
+		if e.Command != "SetVoltage" {
+			t.Fatalf("unexpected audit entry: %+v", e)
+		}
+		return e.Old
+	}
+
+	// The setpoint is read from the device, even if it was
+	// never set via the API or changed on the front panel.
+	if old := putVoltage("panel", "10"); old != 7.5 {
+		t.Errorf("panel: got old value %v, want 7.5", old)
+	}
+	panel.voltage = 3
+	if old := putVoltage("panel", "12"); old != 3.0 {
+		t.Errorf("panel: got old value %v, want 3", old)
+	}
+
+	// Without SetpointReader, the value last set via the API is used.
+	if old := putVoltage("dummy", "10"); old != nil {
+		t.Errorf("dummy: got old value %v, want null", old)
+	}
+	if old := putVoltage("dummy", "12"); old != 10.0 {
+		t.Errorf("dummy: got old value %v, want 10", old)
+	}
+}
@@ -0,0 +1,217 @@
+package opennetzteil
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Permission int
+
+const (
+	PermissionRead Permission = iota
+	PermissionControl
+	PermissionAdmin
+)
+
+func ParsePermission(s string) (Permission, error) {
+	switch strings.ToLower(s) {
+	case "read", "read-only", "":
+		return PermissionRead, nil
+	case "control":
+		return PermissionControl, nil
+	case "admin":
+		return PermissionAdmin, nil
+	}
+	return 0, fmt.Errorf("invalid permission: %s", s)
+}
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionControl:
+		return "control"
+	case PermissionAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// Principal is an authenticated client. A principal is identified
+// either by a static bearer token, by its name in the htpasswd file,
+// or by the common name of a verified TLS client certificate.
+type Principal struct {
+	Name       string
+	Token      string
+	Permission Permission
+	// Devices restricts the principal to the listed device ids.
+	// An empty list grants access to all devices.
+	Devices []string
+}
+
+// mayAccess checks whether the principal has access to a device
+// known by any of the ids in aliases.
+func (p *Principal) mayAccess(aliases ...string) bool {
+	if len(p.Devices) == 0 {
+		return true
+	}
+	for _, d := range p.Devices {
+		for _, alias := range aliases {
+			if alias != "" && d == alias {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Authenticator struct {
+	Principals []Principal
+	// Htpasswd maps user names to password hashes.
+	// bcrypt and {SHA} hashes are supported.
+	Htpasswd map[string]string
+}
+
+// LoadHtpasswd reads an apache style htpasswd file.
+func LoadHtpasswd(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		users   = make(map[string]string)
+		scanner = bufio.NewScanner(file)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid htpasswd line: %s", line)
+		}
+		users[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(hash, "{SHA}")), []byte(expected)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (a *Authenticator) lookup(name string) (*Principal, error) {
+	for i := range a.Principals {
+		if a.Principals[i].Name == name {
+			return &a.Principals[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no permissions configured for '%s'", name)
+}
+
+// Authenticate identifies the client of r. Bearer tokens are tried
+// first, then HTTP basic auth and finally verified client certificates.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		for i := range a.Principals {
+			p := &a.Principals[i]
+			if p.Token != "" && subtle.ConstantTimeCompare([]byte(p.Token), []byte(token)) == 1 {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, ok := a.Htpasswd[user]
+		if !ok || !checkPassword(hash, password) {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return a.lookup(user)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return a.lookup(r.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}
+	return nil, fmt.Errorf("authentication required")
+}
+
+// deviceAliases returns all ids which refer to the same device as id.
+func (s *HTTPServer) deviceAliases(id string) []string {
+	d, pos, err := s.Devices.Lookup(id)
+	if err != nil {
+		return []string{id}
+	}
+	return []string{id, strconv.Itoa(pos), d.Name, d.Ident().Serial}
+}
+
+// mayAccessDevice checks whether the client of r has access to the
+// device id. Without authentication, all devices are accessible.
+func (s *HTTPServer) mayAccessDevice(r *http.Request, id string) bool {
+	p := principalFromContext(r.Context())
+	return p == nil || p.mayAccess(s.deviceAliases(id)...)
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// clientIdentity returns the name of the authenticated principal.
+// Without authentication, the Netzteil-Owner header is used.
+func clientIdentity(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	return r.Header.Get(OwnerHeader)
+}
+
+func (s *HTTPServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := s.Auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="netzteil"`)
+			sendErrorStatus(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && p.Permission < PermissionControl {
+			sendErrorStatus(w, fmt.Sprintf("'%s' has read-only access", p.Name), http.StatusForbidden)
+			return
+		}
+		if id, ok := mux.Vars(r)["id"]; ok && !p.mayAccess(s.deviceAliases(id)...) {
+			sendErrorStatus(w, fmt.Sprintf("'%s' has no access to device '%s'", p.Name, id), http.StatusForbidden)
+			return
+		}
+		if id, ok := mux.Vars(r)["load"]; ok && !p.mayAccess(s.loadAliases(id)...) {
+			sendErrorStatus(w, fmt.Sprintf("'%s' has no access to load '%s'", p.Name, id), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
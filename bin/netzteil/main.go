@@ -64,7 +64,7 @@ func (c *netzteilClient) getDeviceList() ([]string, error) {
 
 // TODO: find a way to unify getter/setter
 
-func (c *netzteilClient) setOutParam(device, channel uint, state bool) error {
+func (c *netzteilClient) setOutParam(device string, channel uint, state bool) error {
 	var (
 		uri     = *c.baseURL
 		reqPath string
@@ -72,9 +72,9 @@ func (c *netzteilClient) setOutParam(device, channel uint, state bool) error {
 	)
 	// Special case for master channel
 	if channel == 0 {
-		reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/out", device)
+		reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/out", device)
 	} else {
-		reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/out", device, channel)
+		reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/out", device, channel)
 	}
 	uri.Path = path.Join(uri.Path, reqPath)
 	if state {
@@ -103,7 +103,7 @@ func (c *netzteilClient) setOutParam(device, channel uint, state bool) error {
 	return nil
 }
 
-func (c *netzteilClient) getOutParam(device, channel uint) (bool, error) {
+func (c *netzteilClient) getOutParam(device string, channel uint) (bool, error) {
 	var (
 		uri        = *c.baseURL
 		reqPath    string
@@ -111,9 +111,9 @@ func (c *netzteilClient) getOutParam(device, channel uint) (bool, error) {
 	)
 	// Special case for master channel
 	if channel == 0 {
-		reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/out", device)
+		reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/out", device)
 	} else {
-		reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/out", device, channel)
+		reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/out", device, channel)
 	}
 	uri.Path = path.Join(uri.Path, reqPath)
 	resp, err := c.client.Get(uri.String())
@@ -130,13 +130,13 @@ func (c *netzteilClient) getOutParam(device, channel uint) (bool, error) {
 	return parsedResp, nil
 }
 
-func (c *netzteilClient) getVoltage(device uint, channel uint) (float64, error) {
+func (c *netzteilClient) getVoltage(device string, channel uint) (float64, error) {
 	var (
 		uri        = *c.baseURL
 		reqPath    string
 		parsedResp float64
 	)
-	reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/voltage", device, channel)
+	reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/voltage", device, channel)
 	uri.Path = path.Join(uri.Path, reqPath)
 	resp, err := c.client.Get(uri.String())
 	if err != nil {
@@ -148,13 +148,13 @@ func (c *netzteilClient) getVoltage(device uint, channel uint) (float64, error)
 	return parsedResp, nil
 }
 
-func (c *netzteilClient) setVoltage(device uint, channel uint, voltage float64) error {
+func (c *netzteilClient) setVoltage(device string, channel uint, voltage float64) error {
 	var (
 		uri     = *c.baseURL
 		reqPath string
 		body    []byte
 	)
-	reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/voltage", device, channel)
+	reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/voltage", device, channel)
 	uri.Path = path.Join(uri.Path, reqPath)
 	body, _ = json.Marshal(voltage)
 	req, err := http.NewRequest(http.MethodPut, uri.String(), bytes.NewReader(body))
@@ -178,13 +178,13 @@ func (c *netzteilClient) setVoltage(device uint, channel uint, voltage float64)
 	return nil
 }
 
-func (c *netzteilClient) setCurrent(device uint, channel uint, current float64) error {
+func (c *netzteilClient) setCurrent(device string, channel uint, current float64) error {
 	var (
 		uri     = *c.baseURL
 		reqPath string
 		body    []byte
 	)
-	reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/current", device, channel)
+	reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/current", device, channel)
 	uri.Path = path.Join(uri.Path, reqPath)
 	body, _ = json.Marshal(current)
 	req, err := http.NewRequest(http.MethodPut, uri.String(), bytes.NewReader(body))
@@ -208,13 +208,13 @@ func (c *netzteilClient) setCurrent(device uint, channel uint, current float64)
 	return nil
 }
 
-func (c *netzteilClient) getCurrent(device uint, channel uint) (float64, error) {
+func (c *netzteilClient) getCurrent(device string, channel uint) (float64, error) {
 	var (
 		uri        = *c.baseURL
 		reqPath    string
 		parsedResp float64
 	)
-	reqPath = fmt.Sprintf("/_netzteil/api/devices/%d/channels/%d/current", device, channel)
+	reqPath = fmt.Sprintf("/_netzteil/api/devices/%s/channels/%d/current", device, channel)
 	uri.Path = path.Join(uri.Path, reqPath)
 	resp, err := c.client.Get(uri.String())
 	if err != nil {
@@ -226,25 +226,25 @@ func (c *netzteilClient) getCurrent(device uint, channel uint) (float64, error)
 	return parsedResp, nil
 }
 
-func (c *netzteilClient) getChannel(device uint, channel uint) (bool, error) {
+func (c *netzteilClient) getChannel(device string, channel uint) (bool, error) {
 	return c.getOutParam(device, channel)
 }
 
-func (c *netzteilClient) setChannel(device uint, channel uint, state bool) error {
+func (c *netzteilClient) setChannel(device string, channel uint, state bool) error {
 	return c.setOutParam(device, channel, state)
 }
 
-func (c *netzteilClient) getMaster(device uint) (bool, error) {
+func (c *netzteilClient) getMaster(device string) (bool, error) {
 	return c.getOutParam(device, 0)
 }
 
-func (c *netzteilClient) setMaster(device uint, state bool) error {
+func (c *netzteilClient) setMaster(device string, state bool) error {
 	return c.setOutParam(device, 0, state)
 }
 
 func main() {
 	var (
-		device  = pflag.UintP("device", "d", 1, "device index")
+		device  = pflag.StringP("device", "d", "1", "device index, name, or serial number")
 		channel = pflag.UintP("channel", "c", 1, "channel index")
 		op      = pflag.StringP("operation", "o", "get", "operation, either 'get', 'set', or 'cont'")
 		opArg   = pflag.StringP("arg", "a", "", "argument for the operation")
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// generateCertificate creates a self-signed certificate for the
+// local hostname. It is used on first start if neither the configured
+// certificate nor the key exist yet.
+func generateCertificate(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname},
+		DNSNames:              []string{hostname, "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	return nil
+}
+
+func initTLS(conf *HTTPConfig) (*tls.Config, error) {
+	if conf.TLSCert == "" && conf.TLSKey == "" {
+		return nil, nil
+	}
+	if conf.TLSCert == "" || conf.TLSKey == "" {
+		return nil, fmt.Errorf("both tls_cert and tls_key are required")
+	}
+	_, certErr := os.Stat(conf.TLSCert)
+	_, keyErr := os.Stat(conf.TLSKey)
+	switch {
+	case os.IsNotExist(certErr) && os.IsNotExist(keyErr):
+		if err := generateCertificate(conf.TLSCert, conf.TLSKey); err != nil {
+			return nil, fmt.Errorf("generating certificate failed: %w", err)
+		}
+	case os.IsNotExist(certErr):
+		return nil, fmt.Errorf("tls_key exists but tls_cert does not: %s", conf.TLSCert)
+	case os.IsNotExist(keyErr):
+		return nil, fmt.Errorf("tls_cert exists but tls_key does not: %s", conf.TLSKey)
+	}
+	cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ClientCA != "" {
+		data, err := ioutil.ReadFile(conf.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in: %s", conf.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		if conf.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+// listen creates a listener for addr. addr is either a TCP address,
+// such as ":8000", or a unix socket URL, such as "unix:///run/netzteil.sock".
+// TLS is only used for TCP listeners; unix sockets are protected by
+// filesystem permissions.
+func listen(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		return listenUnix(u.Path)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		return tls.NewListener(ln, tlsConfig), nil
+	}
+	return ln, nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func listenUnix(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("unix sockets are not supported on this platform: %s", path)
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// unixListener removes the socket on Close(). The socket is
+// renamed after creation, so net.UnixListener cannot do this.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// listenUnix creates a unix socket at path with mode 0660. The socket
+// is created in a private directory, restricted, and moved into place
+// afterwards; thus, it is never reachable with default permissions.
+// Changing the umask instead would affect all goroutines.
+func listenUnix(path string) (net.Listener, error) {
+	// Remove a stale socket from a previous run.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".netzteild-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: ln, path: path}, nil
+}
@@ -1,19 +1,31 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rumpelsepp/opennetzteil"
 	"github.com/rumpelsepp/opennetzteil/devices/dummy"
+	"github.com/rumpelsepp/opennetzteil/devices/keysight"
+	"github.com/rumpelsepp/opennetzteil/devices/modbus"
+	"github.com/rumpelsepp/opennetzteil/devices/riden"
+	"github.com/rumpelsepp/opennetzteil/devices/rigol"
 	"github.com/rumpelsepp/opennetzteil/devices/rnd"
 	"github.com/rumpelsepp/opennetzteil/devices/rs"
+	"github.com/rumpelsepp/opennetzteil/devices/scpi"
+	"github.com/rumpelsepp/opennetzteil/devices/siglent"
 	"git.sr.ht/~sircmpwn/getopt"
 	"github.com/Fraunhofer-AISEC/penlogger"
 	"github.com/pelletier/go-toml"
@@ -35,18 +47,55 @@ type runtimeOptions struct {
 }
 
 type HTTPConfig struct {
-	Bind string
+	Bind              string
+	Listen            []string
+	TLSCert           string `toml:"tls_cert"`
+	TLSKey            string `toml:"tls_key"`
+	ClientCA          string `toml:"client_ca"`
+	RequireClientCert bool   `toml:"require_client_cert"`
 }
 
 type NetzteilConfig struct {
+	Handle           string
+	Model            string
+	Name             string
+	RestoreSetpoints bool `toml:"restore_setpoints"`
+	// Registers is the register map of the modbus-generic model.
+	Registers *modbus.RegisterMap
+	// Commands is the command template set of the scpi-generic model.
+	Commands *scpi.Commands
+}
+
+type LastConfig struct {
 	Handle string
 	Model  string
 	Name   string
 }
 
+type PrincipalConfig struct {
+	Name       string
+	Token      string
+	Permission string
+	Devices    []string
+}
+
+type AuthConfig struct {
+	Htpasswd   string
+	Principals []PrincipalConfig
+}
+
+type AuditConfig struct {
+	File string
+	// Entries is the number of entries kept in memory.
+	Entries int
+}
+
 type config struct {
 	HTTP      HTTPConfig
+	Auth      AuthConfig
+	Audit     AuditConfig
 	Netzteile []NetzteilConfig
+	Lasten    []LastConfig
 }
 
 func loadConfig(path string) (*config, error) {
@@ -70,10 +119,40 @@ func configPath() string {
 	return filepath.Join(path, "netzteil/config.toml")
 }
 
-func initNetzteile(conf *config) ([]opennetzteil.Netzteil, error) {
-	var netzteile []opennetzteil.Netzteil
+func initAuth(conf *config) (*opennetzteil.Authenticator, error) {
+	if len(conf.Auth.Principals) == 0 {
+		return nil, nil
+	}
+	var auth opennetzteil.Authenticator
+	for _, pc := range conf.Auth.Principals {
+		perm, err := opennetzteil.ParsePermission(pc.Permission)
+		if err != nil {
+			return nil, err
+		}
+		auth.Principals = append(auth.Principals, opennetzteil.Principal{
+			Name:       pc.Name,
+			Token:      pc.Token,
+			Permission: perm,
+			Devices:    pc.Devices,
+		})
+	}
+	if conf.Auth.Htpasswd != "" {
+		htpasswd, err := opennetzteil.LoadHtpasswd(conf.Auth.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		auth.Htpasswd = htpasswd
+	}
+	return &auth, nil
+}
+
+func initNetzteile(conf *config) ([]*opennetzteil.Device, error) {
+	var devices []*opennetzteil.Device
 	for _, nc := range conf.Netzteile {
-		var nt opennetzteil.Netzteil
+		var (
+			nc   = nc
+			open func() (opennetzteil.Netzteil, error)
+		)
 		handle, err := url.Parse(nc.Handle)
 		if err != nil {
 			return nil, err
@@ -81,35 +160,243 @@ func initNetzteile(conf *config) ([]opennetzteil.Netzteil, error) {
 
 		switch nc.Model {
 		case "dummy":
-			nt = &dummy.DummyDevice{
-				NetzteilBase: opennetzteil.NetzteilBase{
-					Ident: "dummy-device",
-					Name:  nc.Name,
-				},
+			open = func() (opennetzteil.Netzteil, error) {
+				return &dummy.DummyDevice{
+					NetzteilBase: opennetzteil.NetzteilBase{
+						Ident: "dummy-device",
+						Name:  nc.Name,
+					},
+				}, nil
 			}
-		case "rnd320":
+		case "rnd320", "ka3005":
 			if handle.Scheme != "file" {
 				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
 			}
-			nt, err = rnd.NewRND320(handle.Path, nc.Name)
-			if err != nil {
-				return nil, err
+			open = func() (opennetzteil.Netzteil, error) {
+				return rnd.NewRND320(handle.Path, nc.Name)
 			}
 		case "hmc804":
 			if handle.Scheme != "tcp" {
 				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
 			}
-			nt = rs.NewHMC804(handle.Host, nc.Name)
+			open = func() (opennetzteil.Netzteil, error) {
+				return rs.NewHMC804(handle.Host, nc.Name), nil
+			}
+		case "dp800":
+			switch handle.Scheme {
+			case "tcp":
+				target := handle.Host
+				if handle.Port() == "" {
+					target = net.JoinHostPort(handle.Hostname(), rigol.DefaultPort)
+				}
+				open = func() (opennetzteil.Netzteil, error) {
+					return rigol.NewDP800(target, nc.Name), nil
+				}
+			case "file":
+				open = func() (opennetzteil.Netzteil, error) {
+					return rigol.NewDP800USBTMC(handle.Path, nc.Name)
+				}
+			default:
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+		case "e36xx":
+			switch handle.Scheme {
+			case "tcp":
+				target := handle.Host
+				if handle.Port() == "" {
+					target = net.JoinHostPort(handle.Hostname(), keysight.DefaultPort)
+				}
+				open = func() (opennetzteil.Netzteil, error) {
+					return keysight.NewE36xx(target, nc.Name), nil
+				}
+			case "vxi11":
+				device := strings.TrimPrefix(handle.Path, "/")
+				if device == "" {
+					device = "inst0"
+				}
+				open = func() (opennetzteil.Netzteil, error) {
+					return keysight.NewE36xxVXI11(handle.Host, device, nc.Name), nil
+				}
+			default:
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+		case "rd60xx":
+			if handle.Scheme != "file" {
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+			address := uint64(riden.DefaultAddress)
+			if v := handle.Query().Get("address"); v != "" {
+				address, err = strconv.ParseUint(v, 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid modbus address: %s", v)
+				}
+			}
+			open = func() (opennetzteil.Netzteil, error) {
+				return riden.NewRD60xx(handle.Path, byte(address), nc.Name)
+			}
+		case "modbus-generic":
+			// Reject invalid register maps at startup instead of
+			// on every reconnect. Validate sets the defaults,
+			// so check a copy.
+			if nc.Registers == nil {
+				return nil, fmt.Errorf("%s: register map is missing", nc.Handle)
+			}
+			if err := nc.Registers.Copy().Validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", nc.Handle, err)
+			}
+			address := uint64(1)
+			if v := handle.Query().Get("address"); v != "" {
+				address, err = strconv.ParseUint(v, 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid modbus address: %s", v)
+				}
+			}
+			switch handle.Scheme {
+			case "tcp":
+				target := handle.Host
+				if handle.Port() == "" {
+					target = net.JoinHostPort(handle.Hostname(), modbus.DefaultPort)
+				}
+				open = func() (opennetzteil.Netzteil, error) {
+					return modbus.NewGenericTCP(target, byte(address), nc.Registers, nc.Name)
+				}
+			case "file":
+				open = func() (opennetzteil.Netzteil, error) {
+					return modbus.NewGenericRTU(handle.Path, byte(address), nc.Registers, nc.Name)
+				}
+			default:
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+		case "scpi-generic":
+			// Like the register maps above, reject invalid
+			// command templates at startup.
+			if nc.Commands == nil {
+				return nil, fmt.Errorf("%s: commands are missing", nc.Handle)
+			}
+			if err := nc.Commands.Copy().Validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", nc.Handle, err)
+			}
+			switch handle.Scheme {
+			case "tcp":
+				target := handle.Host
+				if handle.Port() == "" {
+					target = net.JoinHostPort(handle.Hostname(), scpi.DefaultPort)
+				}
+				open = func() (opennetzteil.Netzteil, error) {
+					return scpi.NewGenericTCP(target, nc.Commands, nc.Name)
+				}
+			case "file":
+				open = func() (opennetzteil.Netzteil, error) {
+					return scpi.NewGenericFile(handle.Path, nc.Commands, nc.Name)
+				}
+			default:
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+		case "spd":
+			if handle.Scheme != "tcp" {
+				return nil, fmt.Errorf("invalid handle for: %s", nc.Model)
+			}
+			target := handle.Host
+			if handle.Port() == "" {
+				target = net.JoinHostPort(handle.Hostname(), siglent.DefaultPort)
+			}
+			open = func() (opennetzteil.Netzteil, error) {
+				return siglent.NewSPD(target, nc.Name), nil
+			}
 		default:
 			return nil, fmt.Errorf("unsupported power supply")
 		}
 
-		if err := nt.Probe(); err != nil {
-			return nil, fmt.Errorf("probe failed: %s", err)
+		key := fmt.Sprintf("%s:%s:%s", nc.Model, nc.Handle, nc.Name)
+		if nc.Registers != nil || nc.Commands != nil {
+			// Reopen the device if the register map
+			// or the command templates changed.
+			desc, err := json.Marshal([]interface{}{nc.Registers, nc.Commands})
+			if err != nil {
+				return nil, err
+			}
+			key += fmt.Sprintf(":%08x", crc32.ChecksumIEEE(desc))
+		}
+		devices = append(devices, &opennetzteil.Device{
+			Key:              key,
+			Name:             nc.Name,
+			Model:            nc.Model,
+			Handle:           nc.Handle,
+			Open:             open,
+			RestoreSetpoints: nc.RestoreSetpoints,
+		})
+	}
+	return devices, nil
+}
+
+func initLasten(conf *config) ([]*opennetzteil.Load, error) {
+	var loads []*opennetzteil.Load
+	for _, lc := range conf.Lasten {
+		var (
+			lc   = lc
+			open func() (opennetzteil.Last, error)
+		)
+		handle, err := url.Parse(lc.Handle)
+		if err != nil {
+			return nil, err
+		}
+
+		switch lc.Model {
+		case "dummy":
+			open = func() (opennetzteil.Last, error) {
+				return dummy.NewDummyLoad(lc.Name), nil
+			}
+		case "dl3000":
+			switch handle.Scheme {
+			case "tcp":
+				target := handle.Host
+				if handle.Port() == "" {
+					target = net.JoinHostPort(handle.Hostname(), rigol.DefaultPort)
+				}
+				open = func() (opennetzteil.Last, error) {
+					return rigol.NewDL3000(target, lc.Name), nil
+				}
+			case "file":
+				open = func() (opennetzteil.Last, error) {
+					return rigol.NewDL3000USBTMC(handle.Path, lc.Name)
+				}
+			default:
+				return nil, fmt.Errorf("invalid handle for: %s", lc.Model)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported electronic load")
 		}
-		netzteile = append(netzteile, nt)
+
+		loads = append(loads, &opennetzteil.Load{
+			Key:    fmt.Sprintf("%s:%s:%s", lc.Model, lc.Handle, lc.Name),
+			Name:   lc.Name,
+			Model:  lc.Model,
+			Handle: lc.Handle,
+			Open:   open,
+		})
+	}
+	return loads, nil
+}
+
+// initInstruments creates the devices and loads of conf. Both lists
+// are checked before they are returned; thus, the registries accept
+// them and a reload either applies completely or not at all.
+func initInstruments(conf *config) ([]*opennetzteil.Device, []*opennetzteil.Load, error) {
+	devices, err := initNetzteile(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	loads, err := initLasten(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opennetzteil.CheckDevices(devices); err != nil {
+		return nil, nil, err
+	}
+	if err := opennetzteil.CheckLoads(loads); err != nil {
+		return nil, nil, err
 	}
-	return netzteile, nil
+	return devices, loads, nil
 }
 
 func main() {
@@ -145,7 +432,63 @@ func main() {
 		os.Exit(1)
 	}
 
-	netzteile, err := initNetzteile(config)
+	devices, loads, err := initInstruments(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if config.Audit.Entries <= 0 {
+		config.Audit.Entries = 1000
+	}
+	audit, err := opennetzteil.NewAuditLog(config.Audit.File, config.Audit.Entries, penlogger.NewLogger("audit", os.Stderr))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer audit.Close()
+
+	registry := opennetzteil.NewRegistry(penlogger.NewLogger("devices", os.Stderr))
+	registry.Audit = audit
+	if err := registry.Update(devices); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	loadRegistry := opennetzteil.NewLoadRegistry(penlogger.NewLogger("loads", os.Stderr))
+	loadRegistry.Audit = audit
+	if err := loadRegistry.Update(loads); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	reload := func() error {
+		config, err := loadConfig(opts.config)
+		if err != nil {
+			return err
+		}
+		devices, loads, err := initInstruments(config)
+		if err != nil {
+			return err
+		}
+		// Update() does not fail for checked lists.
+		if err := registry.Update(devices); err != nil {
+			return err
+		}
+		return loadRegistry.Update(loads)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			httpLogger.LogInfo("reloading config")
+			if err := reload(); err != nil {
+				httpLogger.LogErrorf("reload failed: %s", err)
+			}
+		}
+	}()
+
+	auth, err := initAuth(config)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -154,18 +497,48 @@ func main() {
 	apiSRV := opennetzteil.HTTPServer{
 		ReqLog:  &reqLogger,
 		Logger:  httpLogger,
-		Devices: netzteile,
+		Devices: registry,
+		Loads:   loadRegistry,
+		Auth:    auth,
+		Reload:  reload,
 	}
 	apiSRV.Logger.SetLogLevel(penlogger.PrioDebug)
+	// No WriteTimeout; it would cut off event streams.
 	srv := &http.Server{
-		Addr:         config.HTTP.Bind,
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      apiSRV.CreateHandler(),
+		ReadTimeout: time.Second * 15,
+		IdleTimeout: time.Second * 60,
+		Handler:     apiSRV.CreateHandler(),
+	}
+
+	tlsConfig, err := initTLS(&config.HTTP)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	addrs := config.HTTP.Listen
+	if config.HTTP.Bind != "" {
+		addrs = append(addrs, config.HTTP.Bind)
+	}
+	if len(addrs) == 0 {
+		fmt.Println("no listen address configured")
+		os.Exit(1)
+	}
+
+	errCh := make(chan error)
+	for _, addr := range addrs {
+		ln, err := listen(addr, tlsConfig)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		httpLogger.LogInfof("listening on %s", addr)
+		go func() {
+			errCh <- srv.Serve(ln)
+		}()
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	if err := <-errCh; err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -0,0 +1,439 @@
+package opennetzteil_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/rumpelsepp/opennetzteil"
+	"github.com/rumpelsepp/opennetzteil/devices/dummy"
+	"github.com/rumpelsepp/opennetzteil/devices/keysight"
+	"github.com/rumpelsepp/opennetzteil/devices/modbus"
+	"github.com/rumpelsepp/opennetzteil/devices/riden"
+	"github.com/rumpelsepp/opennetzteil/devices/rigol"
+	"github.com/rumpelsepp/opennetzteil/devices/rnd"
+	"github.com/rumpelsepp/opennetzteil/devices/rs"
+	"github.com/rumpelsepp/opennetzteil/devices/scpi"
+	"github.com/rumpelsepp/opennetzteil/devices/siglent"
+)
+
+// driverCase describes a driver with a fake device. open creates
+// a new, unprobed driver instance.
+type driverCase struct {
+	name     string
+	channels int
+	open     func(t *testing.T) func() (opennetzteil.Netzteil, error)
+}
+
+func driverCases() []driverCase {
+	return []driverCase{
+		{
+			name:     "dummy",
+			channels: 1,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				return func() (opennetzteil.Netzteil, error) {
+					return &dummy.DummyDevice{}, nil
+				}
+			},
+		},
+		{
+			name:     "rnd320",
+			channels: 1,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				path := fakePTY(t, fakeRND320(map[string]string{
+					"*IDN?":   "KORAD KA3005P V5.8 SN:03379314",
+					"STATUS?": "\x51",
+					"VOUT1?":  "05.00",
+					"IOUT1?":  "0.100",
+					"VSET1?":  "05.00",
+					"ISET1?":  "1.000",
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					return rnd.NewRND320(path, "")
+				}
+			},
+		},
+		{
+			name:     "hmc804",
+			channels: 3,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := fakeSCPI(t, scpiReplies(map[string]string{
+					"*IDN?": "Rohde&Schwarz,HMC8043,012345678,HW50020001/SW2.51",
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					return rs.NewHMC804(addr, ""), nil
+				}
+			},
+		},
+		{
+			name:     "dp800",
+			channels: 3,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := fakeSCPI(t, scpiReplies(map[string]string{
+					"*IDN?":       "RIGOL TECHNOLOGIES,DP832,DP8A000000001,00.01.14",
+					":MEAS:ALL?":  "5.000,0.100,0.500",
+					":OUTP:MODE?": "CV",
+					":OUTP?":      "OFF",
+					":OUTP:OCP?":  "OFF",
+					":OUTP:OVP?":  "OFF",
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					return rigol.NewDP800(addr, ""), nil
+				}
+			},
+		},
+		{
+			name:     "spd",
+			channels: 2,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := fakeSCPI(t, scpiReplies(map[string]string{
+					"*IDN?":      "Siglent Technologies,SPD3303X,SPD3XIDD4R0000,1.01.01.02.05,V3.0",
+					"SYST:STAT?": "0x0000",
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					return siglent.NewSPD(addr, ""), nil
+				}
+			},
+		},
+		{
+			name:     "e36xx",
+			channels: 3,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := fakeSCPI(t, scpiReplies(map[string]string{
+					"*IDN?":     "Keysight Technologies,E36313A,MY00000001,2.0.0",
+					"SYST:ERR?": `+0,"No error"`,
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					return keysight.NewE36xx(addr, ""), nil
+				}
+			},
+		},
+		{
+			name:     "rd60xx",
+			channels: 1,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				m := newFakeModbus(map[uint16]uint16{0: 60062, 2: 1234, 3: 128})
+				path := fakePTY(t, m.serveRTU)
+				return func() (opennetzteil.Netzteil, error) {
+					return riden.NewRD60xx(path, 1, "")
+				}
+			},
+		},
+		{
+			name:     "modbus-generic",
+			channels: 2,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := newFakeModbus(nil).serveTCP(t)
+				return func() (opennetzteil.Netzteil, error) {
+					regs := &modbus.RegisterMap{
+						Channels:        2,
+						Stride:          16,
+						MaxVoltage:      30,
+						MaxCurrent:      5,
+						VoltageSetpoint: &modbus.Register{Address: 0, Scale: 0.01},
+						CurrentSetpoint: &modbus.Register{Address: 1, Scale: 0.01},
+						Voltage:         &modbus.Register{Address: 2, Scale: 0.01},
+						Current:         &modbus.Register{Address: 3, Scale: 0.01},
+						Output:          &modbus.Register{Address: 0, Type: modbus.TypeCoil},
+						Mode:            &modbus.Register{Address: 4},
+						Protection:      &modbus.Register{Address: 5},
+						OCP:             &modbus.Register{Address: 1, Type: modbus.TypeCoil},
+						OVP:             &modbus.Register{Address: 2, Type: modbus.TypeCoil},
+					}
+					return modbus.NewGenericTCP(addr, 1, regs, "")
+				}
+			},
+		},
+		{
+			name:     "scpi-generic",
+			channels: 2,
+			open: func(t *testing.T) func() (opennetzteil.Netzteil, error) {
+				addr := fakeSCPI(t, scpiReplies(map[string]string{
+					"*IDN?": "ACME,PSU-2,1,1.0",
+					"MODE":  "CV",
+				}))
+				return func() (opennetzteil.Netzteil, error) {
+					cmds := &scpi.Commands{
+						Channels:           2,
+						MaxVoltage:         30,
+						MaxCurrent:         5,
+						GetMaster:          "OUTP:GEN?",
+						SetMaster:          "OUTP:GEN {v}",
+						GetVoltage:         "MEAS{ch}:VOLT?",
+						SetVoltage:         "SOUR{ch}:VOLT {v:.3f}",
+						GetCurrent:         "MEAS{ch}:CURR?",
+						SetCurrent:         "SOUR{ch}:CURR {v:.3f}",
+						GetOut:             "OUTP{ch}?",
+						SetOut:             "OUTP{ch} {v}",
+						GetOCP:             "SOUR{ch}:CURR:PROT?",
+						SetOCP:             "SOUR{ch}:CURR:PROT {v}",
+						GetOVP:             "SOUR{ch}:VOLT:PROT?",
+						SetOVP:             "SOUR{ch}:VOLT:PROT {v}",
+						GetMode:            "MODE{ch}?",
+						GetVoltageSetpoint: "SOUR{ch}:VOLT?",
+						GetCurrentSetpoint: "SOUR{ch}:CURR?",
+					}
+					return scpi.NewGenericTCP(addr, cmds, "")
+				}
+			},
+		},
+	}
+}
+
+// errSkip marks operations of optional interfaces
+// which are not implemented by a driver.
+var errSkip = errors.New("skipped")
+
+var channelOps = []struct {
+	name string
+	f    func(nt opennetzteil.Netzteil, ch int) error
+}{
+	{"GetVoltage", func(nt opennetzteil.Netzteil, ch int) error { _, err := nt.GetVoltage(ch); return err }},
+	{"GetCurrent", func(nt opennetzteil.Netzteil, ch int) error { _, err := nt.GetCurrent(ch); return err }},
+	{"SetVoltage", func(nt opennetzteil.Netzteil, ch int) error { return nt.SetVoltage(ch, 1) }},
+	{"SetCurrent", func(nt opennetzteil.Netzteil, ch int) error { return nt.SetCurrent(ch, 0.1) }},
+	{"GetOut", func(nt opennetzteil.Netzteil, ch int) error { _, err := nt.GetOut(ch); return err }},
+	{"SetOut", func(nt opennetzteil.Netzteil, ch int) error { return nt.SetOut(ch, false) }},
+	{"GetOCP", func(nt opennetzteil.Netzteil, ch int) error { _, err := nt.GetOCP(ch); return err }},
+	{"SetOCP", func(nt opennetzteil.Netzteil, ch int) error { return nt.SetOCP(ch, true) }},
+	{"GetOVP", func(nt opennetzteil.Netzteil, ch int) error { _, err := nt.GetOVP(ch); return err }},
+	{"SetOVP", func(nt opennetzteil.Netzteil, ch int) error { return nt.SetOVP(ch, true) }},
+	{"GetRating", func(nt opennetzteil.Netzteil, ch int) error {
+		r, ok := nt.(opennetzteil.Rater)
+		if !ok {
+			return errSkip
+		}
+		_, err := r.GetRating(ch)
+		return err
+	}},
+	{"GetMode", func(nt opennetzteil.Netzteil, ch int) error {
+		m, ok := nt.(opennetzteil.ModeReader)
+		if !ok {
+			return errSkip
+		}
+		_, err := m.GetMode(ch)
+		return err
+	}},
+	{"GetProtectionTripped", func(nt opennetzteil.Netzteil, ch int) error {
+		p, ok := nt.(opennetzteil.ProtectionReporter)
+		if !ok {
+			return errSkip
+		}
+		_, err := p.GetProtectionTripped(ch)
+		return err
+	}},
+	{"GetVoltageSetpoint", func(nt opennetzteil.Netzteil, ch int) error {
+		r, ok := nt.(opennetzteil.SetpointReader)
+		if !ok {
+			return errSkip
+		}
+		_, err := r.GetVoltageSetpoint(ch)
+		return err
+	}},
+	{"GetCurrentSetpoint", func(nt opennetzteil.Netzteil, ch int) error {
+		r, ok := nt.(opennetzteil.SetpointReader)
+		if !ok {
+			return errSkip
+		}
+		_, err := r.GetCurrentSetpoint(ch)
+		return err
+	}},
+}
+
+// TestConformanceChannels checks that all drivers accept exactly
+// the channels 1 to GetChannels() and reject all others with
+// ErrInvalidChannel, including the master channel.
+func TestConformanceChannels(t *testing.T) {
+	for _, tc := range driverCases() {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			nt, err := tc.open(t)()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if c, ok := nt.(io.Closer); ok {
+					c.Close()
+				}
+			}()
+			if err := nt.Probe(); err != nil {
+				t.Fatalf("Probe: %s", err)
+			}
+			n, err := nt.GetChannels()
+			if err != nil {
+				t.Fatalf("GetChannels: %s", err)
+			}
+			if n != tc.channels {
+				t.Fatalf("GetChannels: got %d, want %d", n, tc.channels)
+			}
+
+			for _, op := range channelOps {
+				err := op.f(nt, 1)
+				if err == errSkip || errors.Is(err, opennetzteil.ErrNotImplemented) {
+					// Unsupported operations need not check the channel.
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s(1): %s", op.name, err)
+				}
+				if err := op.f(nt, n); err != nil {
+					t.Errorf("%s(%d): %s", op.name, n, err)
+				}
+				for _, ch := range []int{-1, opennetzteil.MasterChannel, n + 1} {
+					if err := op.f(nt, ch); !errors.Is(err, opennetzteil.ErrInvalidChannel) {
+						t.Errorf("%s(%d): got %v, want ErrInvalidChannel", op.name, ch, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestConformanceHTTP checks the channel handling of the HTTP API,
+// in particular that channel 0 addresses the master output.
+func TestConformanceHTTP(t *testing.T) {
+	for _, tc := range driverCases() {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			logger := penlogger.NewLogger("test", io.Discard)
+			registry := opennetzteil.NewRegistry(logger)
+			registry.HealthInterval = time.Hour
+			registry.Update([]*opennetzteil.Device{{Key: tc.name, Model: tc.name, Open: tc.open(t)}})
+			defer registry.Close()
+
+			api := opennetzteil.HTTPServer{
+				ReqLog:  io.Discard,
+				Logger:  logger,
+				Devices: registry,
+			}
+			srv := httptest.NewServer(api.CreateHandler())
+			defer srv.Close()
+
+			request := func(method, path, body string) (int, string) {
+				req, err := http.NewRequest(method, srv.URL+"/_netzteil/api/devices/1"+path, strings.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				var apiErr struct {
+					Code string `json:"code"`
+				}
+				if resp.StatusCode >= 400 {
+					json.NewDecoder(resp.Body).Decode(&apiErr)
+				}
+				return resp.StatusCode, apiErr.Code
+			}
+
+			status, _ := request(http.MethodGet, "/channels", "")
+			if status != http.StatusOK {
+				t.Fatalf("GET /channels: %d", status)
+			}
+			for _, ch := range []int{1, tc.channels} {
+				for _, path := range []string{"voltage", "current", "out"} {
+					if status, code := request(http.MethodGet, fmt.Sprintf("/channels/%d/%s", ch, path), ""); status != http.StatusOK {
+						t.Errorf("GET channel %d %s: %d %s", ch, path, status, code)
+					}
+				}
+			}
+			for _, ch := range []int{opennetzteil.MasterChannel, tc.channels + 1} {
+				for _, path := range []string{"voltage", "current", "ocp", "ovp", "state"} {
+					status, code := request(http.MethodGet, fmt.Sprintf("/channels/%d/%s", ch, path), "")
+					if status != http.StatusNotFound || code != "invalid_channel" {
+						t.Errorf("GET channel %d %s: %d %s, want 404 invalid_channel", ch, path, status, code)
+					}
+				}
+				status, code := request(http.MethodPut, fmt.Sprintf("/channels/%d/voltage", ch), "1")
+				if status != http.StatusNotFound || code != "invalid_channel" {
+					t.Errorf("PUT channel %d voltage: %d %s, want 404 invalid_channel", ch, status, code)
+				}
+			}
+			for _, ch := range []int{opennetzteil.MasterChannel, tc.channels + 1} {
+				for _, req := range []struct{ method, path, body string }{
+					{http.MethodPut, "timer", "true"},
+					{http.MethodPut, "timer/steps", "[]"},
+					{http.MethodGet, "fuse/delay", ""},
+					{http.MethodPut, "fuse/delay", "10"},
+					{http.MethodPut, "fuse/links/1", ""},
+				} {
+					status, code := request(req.method, fmt.Sprintf("/channels/%d/%s", ch, req.path), req.body)
+					if status != http.StatusNotFound || code != "invalid_channel" {
+						t.Errorf("%s channel %d %s: %d %s, want 404 invalid_channel", req.method, ch, req.path, status, code)
+					}
+				}
+			}
+			// Only out and leases accept channel 0 besides channels 1..n;
+			// channels beyond the last one are rejected there as well.
+			status, code := request(http.MethodGet, fmt.Sprintf("/channels/%d/out", tc.channels+1), "")
+			if status != http.StatusNotFound || code != "invalid_channel" {
+				t.Errorf("GET channel %d out: %d %s, want 404 invalid_channel", tc.channels+1, status, code)
+			}
+			status, code = request(http.MethodPost, fmt.Sprintf("/channels/%d/lease", tc.channels+1), "1000")
+			if status != http.StatusNotFound || code != "invalid_channel" {
+				t.Errorf("POST channel %d lease: %d %s, want 404 invalid_channel", tc.channels+1, status, code)
+			}
+
+			// Channel 0 is the master output.
+			status, code = request(http.MethodGet, "/channels/0/out", "")
+			if status != http.StatusOK && status != http.StatusNotImplemented {
+				t.Errorf("GET master out: %d %s", status, code)
+			}
+			status, code = request(http.MethodPut, "/channels/0/out", "false")
+			if status != http.StatusOK && status != http.StatusNotImplemented {
+				t.Errorf("PUT master out: %d %s", status, code)
+			}
+
+			// Optional endpoints are implemented
+			// iff the capability is reported.
+			resp, err := http.Get(srv.URL + "/_netzteil/api/v2/devices/1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var info struct {
+				Capabilities []string `json:"capabilities"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&info)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			caps := make(map[string]bool)
+			for _, c := range info.Capabilities {
+				caps[c] = true
+			}
+			optional := []struct {
+				capability string
+				method     string
+				path       string
+				body       string
+			}{
+				{opennetzteil.CapabilityPreset, http.MethodGet, "/presets", ""},
+				{opennetzteil.CapabilityTracking, http.MethodPut, "/tracking", `"independent"`},
+				{opennetzteil.CapabilityTimer, http.MethodPut, "/channels/1/timer", "false"},
+				{opennetzteil.CapabilityPanelLock, http.MethodPut, "/panel-lock", "true"},
+				{opennetzteil.CapabilityFuse, http.MethodGet, "/channels/1/fuse/delay", ""},
+				{opennetzteil.CapabilityFuse, http.MethodPut, "/channels/1/fuse/delay", "10"},
+				{opennetzteil.CapabilityFuse, http.MethodPut, fmt.Sprintf("/channels/1/fuse/links/%d", tc.channels), ""},
+			}
+			for _, o := range optional {
+				status, code := request(o.method, o.path, o.body)
+				switch {
+				case caps[o.capability] && status != http.StatusOK:
+					t.Errorf("%s %s: %d %s", o.method, o.path, status, code)
+				case !caps[o.capability] && status != http.StatusNotImplemented:
+					t.Errorf("%s %s: %d %s, want 501 without capability", o.method, o.path, status, code)
+				}
+			}
+		})
+	}
+}
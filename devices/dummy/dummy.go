@@ -1,6 +1,10 @@
 package dummy
 
-import "github.com/rumpelsepp/opennetzteil"
+import (
+	"fmt"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
 
 type DummyDevice struct {
 	opennetzteil.NetzteilBase
@@ -10,6 +14,10 @@ func (d *DummyDevice) Probe() error {
 	return nil
 }
 
+func (d *DummyDevice) Ping() error {
+	return nil
+}
+
 func (d *DummyDevice) Status() (interface{}, error) {
 	return nil, nil
 }
@@ -26,43 +34,102 @@ func (d *DummyDevice) SetBeep(enabled bool) error {
 	return nil
 }
 
+func (d *DummyDevice) Capabilities() []string {
+	return []string{
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityBeep,
+		opennetzteil.CapabilityOCP,
+		opennetzteil.CapabilityOVP,
+	}
+}
+
+func (d *DummyDevice) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5}, nil
+}
+
+func (d *DummyDevice) GetMode(channel int) (string, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return "", err
+	}
+	return opennetzteil.ModeCV, nil
+}
+
 func (d *DummyDevice) GetChannels() (int, error) {
 	return 1, nil
 }
 
 func (d *DummyDevice) GetCurrent(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
 	return 12, nil
 }
 func (d *DummyDevice) SetOut(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (d *DummyDevice) GetOut(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 func (d *DummyDevice) SetCurrent(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if current < 0 || current > 5 {
+		return fmt.Errorf("%w: current %.3f A; must be 0-5 A", opennetzteil.ErrOutOfRange, current)
+	}
 	return nil
 }
 
 func (d *DummyDevice) GetVoltage(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
 	return 15, nil
 }
 
 func (d *DummyDevice) SetVoltage(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if voltage < 0 || voltage > 30 {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-30 V", opennetzteil.ErrOutOfRange, voltage)
+	}
 	return nil
 }
 func (d *DummyDevice) GetOCP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 func (d *DummyDevice) SetOCP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
 	return nil
 }
 func (d *DummyDevice) GetOVP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 func (d *DummyDevice) SetOVP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,146 @@
+package dummy
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// The simulated load is connected to a source
+// with an open-circuit voltage and a series resistance.
+const (
+	sourceVoltage    = 12.0
+	sourceResistance = 0.5
+)
+
+// DummyLoad simulates an electronic load. Measurements
+// are computed from the setpoint of the active mode.
+type DummyLoad struct {
+	opennetzteil.NetzteilBase
+
+	mutex     sync.Mutex
+	mode      string
+	input     bool
+	setpoints map[string]float64
+}
+
+func NewDummyLoad(name string) *DummyLoad {
+	return &DummyLoad{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		mode:         opennetzteil.ModeCC,
+		setpoints: map[string]float64{
+			opennetzteil.ModeCC: 1,
+			opennetzteil.ModeCV: 10,
+			opennetzteil.ModeCR: 10,
+			opennetzteil.ModeCP: 10,
+		},
+	}
+}
+
+func (d *DummyLoad) Probe() error {
+	d.SetIdent("opennetzteil,DummyLoad,0,0")
+	return nil
+}
+
+func (d *DummyLoad) GetMode() (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.mode, nil
+}
+
+func (d *DummyLoad) SetMode(mode string) error {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.mode = mode
+	return nil
+}
+
+func (d *DummyLoad) GetSetpoint(mode string) (float64, error) {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return 0, err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.setpoints[mode], nil
+}
+
+func (d *DummyLoad) SetSetpoint(mode string, value float64) error {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return err
+	}
+	if value < 0 {
+		return fmt.Errorf("%w: setpoint %g; must not be negative", opennetzteil.ErrOutOfRange, value)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.setpoints[mode] = value
+	return nil
+}
+
+func (d *DummyLoad) GetInput() (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.input, nil
+}
+
+func (d *DummyLoad) SetInput(enabled bool) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.input = enabled
+	return nil
+}
+
+// operatingPoint returns voltage and current at the load terminals.
+func (d *DummyLoad) operatingPoint() (float64, float64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.input {
+		return sourceVoltage, 0
+	}
+	var i float64
+	sp := d.setpoints[d.mode]
+	switch d.mode {
+	case opennetzteil.ModeCC:
+		i = sp
+	case opennetzteil.ModeCV:
+		i = (sourceVoltage - sp) / sourceResistance
+	case opennetzteil.ModeCR:
+		i = sourceVoltage / (sp + sourceResistance)
+	case opennetzteil.ModeCP:
+		// P = (U0 - R*I) * I; take the smaller root,
+		// or the maximum power point if P is too large.
+		disc := sourceVoltage*sourceVoltage - 4*sourceResistance*sp
+		if disc < 0 {
+			disc = 0
+		}
+		i = (sourceVoltage - math.Sqrt(disc)) / (2 * sourceResistance)
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i > sourceVoltage/sourceResistance {
+		i = sourceVoltage / sourceResistance
+	}
+	return sourceVoltage - sourceResistance*i, i
+}
+
+func (d *DummyLoad) GetVoltage() (float64, error) {
+	u, _ := d.operatingPoint()
+	return u, nil
+}
+
+func (d *DummyLoad) GetCurrent() (float64, error) {
+	_, i := d.operatingPoint()
+	return i, nil
+}
+
+func (d *DummyLoad) GetPower() (float64, error) {
+	u, i := d.operatingPoint()
+	return u * i, nil
+}
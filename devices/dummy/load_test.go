@@ -0,0 +1,70 @@
+package dummy
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+func TestOperatingPoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    bool
+		mode     string
+		setpoint float64
+		voltage  float64
+		current  float64
+	}{
+		{"input off", false, opennetzteil.ModeCC, 1, 12, 0},
+		{"cc", true, opennetzteil.ModeCC, 1, 11.5, 1},
+		{"cc above short circuit current", true, opennetzteil.ModeCC, 30, 0, 24},
+		{"cv", true, opennetzteil.ModeCV, 10, 10, 4},
+		{"cv above source voltage", true, opennetzteil.ModeCV, 15, 12, 0},
+		{"cr", true, opennetzteil.ModeCR, 5.5, 11, 2},
+		{"cr short circuit", true, opennetzteil.ModeCR, 0, 0, 24},
+		{"cp", true, opennetzteil.ModeCP, 22, 11, 2},
+		// The maximum power is 72 W at 6 V and 12 A.
+		{"cp above maximum power", true, opennetzteil.ModeCP, 100, 6, 12},
+	}
+	for _, tc := range tests {
+		d := NewDummyLoad("")
+		if err := d.SetMode(tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetSetpoint(tc.mode, tc.setpoint); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetInput(tc.input); err != nil {
+			t.Fatal(err)
+		}
+		u, _ := d.GetVoltage()
+		i, _ := d.GetCurrent()
+		p, _ := d.GetPower()
+		if math.Abs(u-tc.voltage) > 1e-9 || math.Abs(i-tc.current) > 1e-9 {
+			t.Errorf("%s: got %g V, %g A, want %g V, %g A", tc.name, u, i, tc.voltage, tc.current)
+		}
+		if math.Abs(p-u*i) > 1e-9 {
+			t.Errorf("%s: got %g W, want %g W", tc.name, p, u*i)
+		}
+	}
+}
+
+func TestLoadSetpoints(t *testing.T) {
+	d := NewDummyLoad("")
+	if err := d.SetSetpoint(opennetzteil.ModeCR, -1); err == nil {
+		t.Error("SetSetpoint accepted a negative setpoint")
+	}
+	if err := d.SetMode("CX"); err == nil {
+		t.Error("SetMode accepted an invalid mode")
+	}
+	if err := d.SetSetpoint(opennetzteil.ModeCV, 5); err != nil {
+		t.Fatal(err)
+	}
+	if mode, _ := d.GetMode(); mode != opennetzteil.ModeCC {
+		t.Errorf("setting the CV setpoint changed the mode to %s", mode)
+	}
+	if sp, _ := d.GetSetpoint(opennetzteil.ModeCV); sp != 5 {
+		t.Errorf("got CV setpoint %g, want 5", sp)
+	}
+}
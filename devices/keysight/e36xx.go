@@ -0,0 +1,574 @@
+package keysight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultPort is the port of the raw SCPI socket.
+const DefaultPort = "5025"
+
+const timeout = 5 * time.Second
+
+type model struct {
+	ratings []opennetzteil.Rating
+	// outputs are the names of the channels for APPL;
+	// they are empty for single output models.
+	outputs []string
+	// channelOutputs is true if the outputs can be switched
+	// per channel; otherwise, OUTP switches all outputs.
+	channelOutputs bool
+	// protection is true if OVP and OCP can be switched
+	// via VOLT:PROT:STAT and CURR:PROT:STAT.
+	protection bool
+	// ovp is true if the OVP is always enabled; its
+	// level is set via VOLT:PROT.
+	ovp bool
+}
+
+var models = map[string]model{
+	"E3631A": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 6, MaxCurrent: 5},
+			{MaxVoltage: 25, MaxCurrent: 1},
+			{MaxVoltage: 25, MaxCurrent: 1},
+		},
+		outputs: []string{"P6V", "P25V", "N25V"},
+	},
+	"E3632A": {
+		ratings:    []opennetzteil.Rating{{MaxVoltage: 30, MaxCurrent: 7, MaxPower: 120}},
+		protection: true,
+	},
+	"E3633A": {
+		ratings:    []opennetzteil.Rating{{MaxVoltage: 20, MaxCurrent: 20, MaxPower: 200}},
+		protection: true,
+	},
+	"E3634A": {
+		ratings:    []opennetzteil.Rating{{MaxVoltage: 50, MaxCurrent: 7, MaxPower: 200}},
+		protection: true,
+	},
+	"E36311A": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 6, MaxCurrent: 5},
+			{MaxVoltage: 25, MaxCurrent: 1},
+			{MaxVoltage: 25, MaxCurrent: 1},
+		},
+		outputs:        []string{"CH1", "CH2", "CH3"},
+		channelOutputs: true,
+		ovp:            true,
+	},
+	"E36312A": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 6, MaxCurrent: 5},
+			{MaxVoltage: 25, MaxCurrent: 1},
+			{MaxVoltage: 25, MaxCurrent: 1},
+		},
+		outputs:        []string{"CH1", "CH2", "CH3"},
+		channelOutputs: true,
+		ovp:            true,
+	},
+	"E36313A": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 6, MaxCurrent: 10},
+			{MaxVoltage: 25, MaxCurrent: 2},
+			{MaxVoltage: 25, MaxCurrent: 2},
+		},
+		outputs:        []string{"CH1", "CH2", "CH3"},
+		channelOutputs: true,
+		ovp:            true,
+	},
+}
+
+// SCPI errors which are reported as ErrOutOfRange.
+var rangeErrors = map[int]bool{
+	-222: true, // Data out of range
+	-224: true, // Illegal parameter value
+}
+
+// E36xx implements the Keysight (formerly Agilent) E36xx series. The
+// device is either connected via a raw socket or via VXI-11. Models
+// without LAN interface can be connected via a VXI-11 GPIB gateway.
+type E36xx struct {
+	opennetzteil.NetzteilBase
+	dial  func() (io.ReadWriteCloser, error)
+	conn  io.ReadWriteCloser
+	model model
+	mutex sync.Mutex
+}
+
+// NewE36xx creates a driver for a device reachable
+// via a raw socket; target is host:port.
+func NewE36xx(target, name string) *E36xx {
+	return &E36xx{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		dial: func() (io.ReadWriteCloser, error) {
+			return net.DialTimeout("tcp", target, timeout)
+		},
+	}
+}
+
+// NewE36xxVXI11 creates a driver for a device reachable via VXI-11;
+// device is the name of the device on host, e.g. "inst0" or "gpib0,5".
+func NewE36xxVXI11(host, device, name string) *E36xx {
+	return &E36xx{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		dial: func() (io.ReadWriteCloser, error) {
+			return opennetzteil.DialVXI11(host, device, timeout)
+		},
+	}
+}
+
+func (nt *E36xx) Close() error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		return nil
+	}
+	err := nt.conn.Close()
+	nt.conn = nil
+	return err
+}
+
+// exchange runs f with the connection to the device. The connection
+// is established on demand and closed after transport errors.
+func (nt *E36xx) exchange(f func(conn io.ReadWriter) error) error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		conn, err := nt.dial()
+		if err != nil {
+			return opennetzteil.TransportError(err)
+		}
+		nt.conn = conn
+	}
+	if c, ok := nt.conn.(net.Conn); ok {
+		if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return opennetzteil.TransportError(err)
+		}
+	}
+	err := f(nt.conn)
+	if errors.Is(err, opennetzteil.ErrTransport) || errors.Is(err, opennetzteil.ErrTimeout) {
+		nt.conn.Close()
+		nt.conn = nil
+	}
+	return err
+}
+
+// query sends cmds and reads back the response of the last command.
+func (nt *E36xx) query(cmds ...string) (string, error) {
+	var resp []byte
+	err := nt.exchange(func(conn io.ReadWriter) error {
+		for _, cmd := range cmds[:len(cmds)-1] {
+			if err := nt.SendCommandLine(conn, []byte(cmd)); err != nil {
+				return err
+			}
+		}
+		var err error
+		resp, err = nt.RequestLine(conn, []byte(cmds[len(cmds)-1]))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(resp)), nil
+}
+
+// command sends cmds and checks the error queue afterwards.
+// The error queue is cleared before.
+func (nt *E36xx) command(cmds ...string) error {
+	return nt.exchange(func(conn io.ReadWriter) error {
+		for _, cmd := range append([]string{"*CLS"}, cmds...) {
+			if err := nt.SendCommandLine(conn, []byte(cmd)); err != nil {
+				return err
+			}
+		}
+		return nt.checkErrors(conn)
+	})
+}
+
+// checkErrors drains the error queue and returns the first error.
+// The response of SYST:ERR? looks like: -222,"Data out of range"
+func (nt *E36xx) checkErrors(conn io.ReadWriter) error {
+	var first error
+	// The error queue holds up to 20 errors.
+	for i := 0; i < 20; i++ {
+		resp, err := nt.RequestLine(conn, []byte("SYST:ERR?"))
+		if err != nil {
+			return err
+		}
+		fields := strings.SplitN(strings.TrimSpace(string(resp)), ",", 2)
+		code, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("unexpected response to 'SYST:ERR?': %s", resp)
+		}
+		if code == 0 {
+			break
+		}
+		if first != nil {
+			continue
+		}
+		msg := ""
+		if len(fields) > 1 {
+			msg = strings.Trim(fields[1], "\"")
+		}
+		if rangeErrors[code] {
+			first = fmt.Errorf("%w: %s", opennetzteil.ErrOutOfRange, msg)
+		} else {
+			first = fmt.Errorf("device error %d: %s", code, msg)
+		}
+	}
+	return first
+}
+
+func (nt *E36xx) queryFloat(cmds ...string) (float64, error) {
+	resp, err := nt.query(cmds...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp, 64)
+}
+
+func (nt *E36xx) queryBool(cmds ...string) (bool, error) {
+	resp, err := nt.query(cmds...)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(resp)
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// selectChannel prepends the selection of channel to cmds;
+// single output models have no INST command.
+func (nt *E36xx) selectChannel(channel int, cmds ...string) []string {
+	if len(nt.model.ratings) == 1 {
+		return cmds
+	}
+	return append([]string{fmt.Sprintf("INST:NSEL %d", channel)}, cmds...)
+}
+
+func (nt *E36xx) Ping() error {
+	_, err := nt.query("*IDN?")
+	return err
+}
+
+// Probe identifies the model. The response of *IDN? looks like:
+// Agilent Technologies,E3631A,0,2.1-5.0-1.0
+func (nt *E36xx) Probe() error {
+	ident, err := nt.query("*IDN?")
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(ident, ",")
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected identification: %s", ident)
+	}
+	m, ok := models[fields[1]]
+	if !ok {
+		return fmt.Errorf("unsupported model: %s", fields[1])
+	}
+	nt.model = m
+	nt.SetIdent(ident)
+	return nil
+}
+
+func (nt *E36xx) checkChannel(channel int) error {
+	return opennetzteil.CheckChannel(channel, len(nt.model.ratings))
+}
+
+func (nt *E36xx) Status() (interface{}, error) {
+	return nil, opennetzteil.ErrNotImplemented
+}
+
+// GetMaster reports whether any output is enabled.
+func (nt *E36xx) GetMaster() (bool, error) {
+	if !nt.model.channelOutputs {
+		return nt.queryBool("OUTP?")
+	}
+	resp, err := nt.query(fmt.Sprintf("OUTP? (@1:%d)", len(nt.model.ratings)))
+	if err != nil {
+		return false, err
+	}
+	for _, f := range strings.Split(resp, ",") {
+		if f == "1" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetMaster switches all outputs.
+func (nt *E36xx) SetMaster(enabled bool) error {
+	if !nt.model.channelOutputs {
+		return nt.command("OUTP " + onOff(enabled))
+	}
+	return nt.command(fmt.Sprintf("OUTP %s,(@1:%d)", onOff(enabled), len(nt.model.ratings)))
+}
+
+func (nt *E36xx) SetBeep(enabled bool) error {
+	return nt.command("SYST:BEEP:STAT " + onOff(enabled))
+}
+
+func (nt *E36xx) Capabilities() []string {
+	caps := []string{
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityBeep,
+	}
+	if nt.model.protection || nt.model.ovp {
+		caps = append(caps, opennetzteil.CapabilityOCP, opennetzteil.CapabilityOVP)
+	}
+	return caps
+}
+
+func (nt *E36xx) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.model.ratings[channel-1], nil
+}
+
+func (nt *E36xx) GetProtectionTripped(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	if !nt.model.protection && !nt.model.ovp {
+		return false, opennetzteil.ErrNotImplemented
+	}
+	ovp, err := nt.queryBool(nt.selectChannel(channel, "VOLT:PROT:TRIP?")...)
+	if err != nil {
+		return false, err
+	}
+	ocp, err := nt.queryBool(nt.selectChannel(channel, "CURR:PROT:TRIP?")...)
+	if err != nil {
+		return false, err
+	}
+	return ovp || ocp, nil
+}
+
+// ClearProtection clears a tripped OCP or OVP of channel.
+func (nt *E36xx) ClearProtection(channel int) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.command(nt.selectChannel(channel, "VOLT:PROT:CLE", "CURR:PROT:CLE")...)
+}
+
+func (nt *E36xx) GetChannels() (int, error) {
+	return len(nt.model.ratings), nil
+}
+
+func (nt *E36xx) GetCurrent(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "MEAS:CURR?")...)
+}
+
+func (nt *E36xx) checkCurrent(channel int, current float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	max := nt.model.ratings[channel-1].MaxCurrent
+	if current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nil
+}
+
+// checkVoltage compares the magnitude of voltage as
+// the third output of the E3631A is a negative output.
+func (nt *E36xx) checkVoltage(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	max := nt.model.ratings[channel-1].MaxVoltage
+	if math.Abs(voltage) > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nil
+}
+
+func (nt *E36xx) SetCurrent(channel int, current float64) error {
+	if err := nt.checkCurrent(channel, current); err != nil {
+		return err
+	}
+	return nt.command(nt.selectChannel(channel, fmt.Sprintf("CURR %.3f", current))...)
+}
+
+func (nt *E36xx) GetVoltage(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "MEAS:VOLT?")...)
+}
+
+func (nt *E36xx) apply(channel int, values string) string {
+	if len(nt.model.outputs) == 0 {
+		return "APPL " + values
+	}
+	return fmt.Sprintf("APPL %s,%s", nt.model.outputs[channel-1], values)
+}
+
+func (nt *E36xx) SetVoltage(channel int, voltage float64) error {
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	return nt.command(nt.apply(channel, fmt.Sprintf("%.3f", voltage)))
+}
+
+func (nt *E36xx) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "VOLT?")...)
+}
+
+func (nt *E36xx) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "CURR?")...)
+}
+
+// Apply sets voltage and current of channel with a single command.
+func (nt *E36xx) Apply(channel int, voltage, current float64) error {
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	if err := nt.checkCurrent(channel, current); err != nil {
+		return err
+	}
+	return nt.command(nt.apply(channel, fmt.Sprintf("%.3f,%.3f", voltage, current)))
+}
+
+// GetOut returns the state of all outputs if
+// they cannot be switched per channel.
+func (nt *E36xx) GetOut(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	if !nt.model.channelOutputs {
+		return nt.queryBool("OUTP?")
+	}
+	return nt.queryBool(fmt.Sprintf("OUTP? (@%d)", channel))
+}
+
+func (nt *E36xx) SetOut(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if !nt.model.channelOutputs {
+		return opennetzteil.ErrNotImplemented
+	}
+	return nt.command(fmt.Sprintf("OUTP %s,(@%d)", onOff(enabled), channel))
+}
+
+func (nt *E36xx) GetOCP(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	if !nt.model.protection && !nt.model.ovp {
+		return false, opennetzteil.ErrNotImplemented
+	}
+	return nt.queryBool(nt.selectChannel(channel, "CURR:PROT:STAT?")...)
+}
+
+func (nt *E36xx) SetOCP(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if !nt.model.protection && !nt.model.ovp {
+		return opennetzteil.ErrNotImplemented
+	}
+	return nt.command(nt.selectChannel(channel, "CURR:PROT:STAT "+onOff(enabled))...)
+}
+
+// GetOCPLevel returns the current at which the OCP trips.
+func (nt *E36xx) GetOCPLevel(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	if !nt.model.protection {
+		return 0, opennetzteil.ErrNotImplemented
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "CURR:PROT?")...)
+}
+
+// SetOCPLevel sets the current at which the OCP trips.
+func (nt *E36xx) SetOCPLevel(channel int, current float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if !nt.model.protection {
+		return opennetzteil.ErrNotImplemented
+	}
+	return nt.command(nt.selectChannel(channel, fmt.Sprintf("CURR:PROT %.3f", current))...)
+}
+
+// GetOVP reports true for models whose OVP is always enabled.
+func (nt *E36xx) GetOVP(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	switch {
+	case nt.model.ovp:
+		return true, nil
+	case !nt.model.protection:
+		return false, opennetzteil.ErrNotImplemented
+	}
+	return nt.queryBool(nt.selectChannel(channel, "VOLT:PROT:STAT?")...)
+}
+
+// SetOVP accepts only true for models whose OVP is always enabled.
+func (nt *E36xx) SetOVP(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	switch {
+	case nt.model.ovp && enabled:
+		return nil
+	case nt.model.ovp:
+		return fmt.Errorf("%w: the OVP of this model cannot be disabled", opennetzteil.ErrOutOfRange)
+	case !nt.model.protection:
+		return opennetzteil.ErrNotImplemented
+	}
+	return nt.command(nt.selectChannel(channel, "VOLT:PROT:STAT "+onOff(enabled))...)
+}
+
+// GetOVPLevel returns the voltage at which the OVP trips.
+func (nt *E36xx) GetOVPLevel(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	if !nt.model.protection && !nt.model.ovp {
+		return 0, opennetzteil.ErrNotImplemented
+	}
+	return nt.queryFloat(nt.selectChannel(channel, "VOLT:PROT?")...)
+}
+
+// SetOVPLevel sets the voltage at which the OVP trips.
+func (nt *E36xx) SetOVPLevel(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if !nt.model.protection && !nt.model.ovp {
+		return opennetzteil.ErrNotImplemented
+	}
+	return nt.command(nt.selectChannel(channel, fmt.Sprintf("VOLT:PROT %.3f", voltage))...)
+}
@@ -0,0 +1,80 @@
+package keysight
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// newFakeE36xx returns a driver for m connected to a fake device
+// which accepts all commands and answers all queries with 0.
+func newFakeE36xx(m model) *E36xx {
+	return &E36xx{
+		model: m,
+		dial: func() (io.ReadWriteCloser, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				r := bufio.NewReader(server)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.TrimSpace(line); {
+					case cmd == "SYST:ERR?":
+						io.WriteString(server, "+0,\"No error\"\n")
+					case strings.HasSuffix(cmd, "?"):
+						io.WriteString(server, "0\n")
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+// TestCapabilities checks that the OCP and OVP capabilities
+// match the getters and setters of each model.
+func TestCapabilities(t *testing.T) {
+	protections := []struct {
+		capability string
+		get        func(nt *E36xx) error
+		set        func(nt *E36xx) error
+	}{
+		{
+			opennetzteil.CapabilityOCP,
+			func(nt *E36xx) error { _, err := nt.GetOCP(1); return err },
+			func(nt *E36xx) error { return nt.SetOCP(1, true) },
+		},
+		{
+			opennetzteil.CapabilityOVP,
+			func(nt *E36xx) error { _, err := nt.GetOVP(1); return err },
+			func(nt *E36xx) error { return nt.SetOVP(1, true) },
+		},
+	}
+	for name, m := range models {
+		nt := newFakeE36xx(m)
+		caps := make(map[string]bool)
+		for _, c := range nt.Capabilities() {
+			caps[c] = true
+		}
+		for _, p := range protections {
+			for op, f := range map[string]func(*E36xx) error{"get": p.get, "set": p.set} {
+				err := f(nt)
+				switch {
+				case caps[p.capability] && err != nil:
+					t.Errorf("%s: %s %s: %s", name, op, p.capability, err)
+				case !caps[p.capability] && !errors.Is(err, opennetzteil.ErrNotImplemented):
+					t.Errorf("%s: %s %s: got %v without capability", name, op, p.capability, err)
+				}
+			}
+		}
+		nt.Close()
+	}
+}
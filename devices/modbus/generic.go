@@ -0,0 +1,589 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultPort is the Modbus TCP port.
+const DefaultPort = "502"
+
+const timeout = time.Second
+
+// Register types.
+const (
+	TypeHolding = "holding"
+	TypeInput   = "input"
+	TypeCoil    = "coil"
+)
+
+// Data types of registers. 32 bit values occupy
+// two registers, the high word first.
+const (
+	DataUint16  = "uint16"
+	DataInt16   = "int16"
+	DataUint32  = "uint32"
+	DataInt32   = "int32"
+	DataFloat32 = "float32"
+)
+
+// Register describes where a value is stored. The value is the raw
+// value multiplied by Scale. Boolean values are true if not zero.
+type Register struct {
+	Address uint16
+	// Type is one of TypeHolding, TypeInput, or TypeCoil.
+	// Defaults to TypeHolding.
+	Type string
+	// DataType defaults to DataUint16.
+	DataType string `toml:"data_type"`
+	// Scale defaults to 1.
+	Scale float64
+}
+
+// RegisterMap describes the registers of a device. Only the ratings
+// are required; functions whose register is missing are not
+// implemented. The registers of channel n are located at Address +
+// (n-1) * Stride.
+type RegisterMap struct {
+	Ident      string
+	Channels   int
+	Stride     uint16
+	MaxVoltage float64 `toml:"max_voltage"`
+	MaxCurrent float64 `toml:"max_current"`
+	MaxPower   float64 `toml:"max_power"`
+
+	VoltageSetpoint *Register `toml:"voltage_setpoint"`
+	CurrentSetpoint *Register `toml:"current_setpoint"`
+	Voltage         *Register
+	Current         *Register
+	Output          *Register
+	// Master is the master output; if missing,
+	// the outputs of all channels are switched.
+	Master *Register
+	// Mode is not zero in constant current mode.
+	Mode *Register
+	// Protection is not zero if the OCP or OVP tripped.
+	Protection *Register
+	OCP        *Register
+	OVP        *Register
+}
+
+func (r *Register) check(name string, writable bool) error {
+	if r == nil {
+		return nil
+	}
+	switch r.Type {
+	case "":
+		r.Type = TypeHolding
+	case TypeHolding, TypeCoil:
+	case TypeInput:
+		if writable {
+			return fmt.Errorf("register %s: input registers are read-only", name)
+		}
+	default:
+		return fmt.Errorf("register %s: invalid type: %s", name, r.Type)
+	}
+	switch r.DataType {
+	case "":
+		r.DataType = DataUint16
+	case DataUint16, DataInt16, DataUint32, DataInt32, DataFloat32:
+	default:
+		return fmt.Errorf("register %s: invalid data type: %s", name, r.DataType)
+	}
+	if r.Type == TypeCoil && r.DataType != DataUint16 {
+		return fmt.Errorf("register %s: coils have no data type", name)
+	}
+	if r.Scale == 0 {
+		r.Scale = 1
+	}
+	return nil
+}
+
+// Copy returns a deep copy of m.
+func (m *RegisterMap) Copy() *RegisterMap {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	for _, reg := range []**Register{
+		&c.VoltageSetpoint, &c.CurrentSetpoint, &c.Voltage, &c.Current,
+		&c.Output, &c.Master, &c.Mode, &c.Protection, &c.OCP, &c.OVP,
+	} {
+		if *reg != nil {
+			r := **reg
+			*reg = &r
+		}
+	}
+	return &c
+}
+
+// Validate checks m and sets the defaults.
+func (m *RegisterMap) Validate() error {
+	if m.MaxVoltage <= 0 || m.MaxCurrent <= 0 {
+		return fmt.Errorf("max_voltage and max_current are required")
+	}
+	if m.Channels == 0 {
+		m.Channels = 1
+	}
+	if m.Channels > 1 && m.Stride == 0 {
+		return fmt.Errorf("stride is required for several channels")
+	}
+	if m.Ident == "" {
+		m.Ident = "Modbus device"
+	}
+	regs := []struct {
+		name     string
+		reg      *Register
+		writable bool
+	}{
+		{"voltage_setpoint", m.VoltageSetpoint, true},
+		{"current_setpoint", m.CurrentSetpoint, true},
+		{"voltage", m.Voltage, false},
+		{"current", m.Current, false},
+		{"output", m.Output, true},
+		{"master", m.Master, true},
+		{"mode", m.Mode, false},
+		{"protection", m.Protection, false},
+		{"ocp", m.OCP, true},
+		{"ovp", m.OVP, true},
+	}
+	for _, r := range regs {
+		if err := r.reg.check(r.name, r.writable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generic implements devices whose registers are described by a RegisterMap.
+type Generic struct {
+	opennetzteil.NetzteilBase
+	dial   func() (io.ReadWriteCloser, error)
+	conn   io.ReadWriteCloser
+	client func(conn io.ReadWriter) *opennetzteil.Modbus
+	modbus *opennetzteil.Modbus
+	regs   *RegisterMap
+	mutex  sync.Mutex
+}
+
+// newGeneric validates a copy of regs; the defaults
+// are not written back to the configuration.
+func newGeneric(regs *RegisterMap, name string) (*Generic, error) {
+	if regs == nil {
+		return nil, fmt.Errorf("register map is missing")
+	}
+	regs = regs.Copy()
+	if err := regs.Validate(); err != nil {
+		return nil, err
+	}
+	return &Generic{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		regs:         regs,
+	}, nil
+}
+
+// NewGenericTCP creates a driver for a Modbus TCP device;
+// target is host:port.
+func NewGenericTCP(target string, address byte, regs *RegisterMap, name string) (*Generic, error) {
+	nt, err := newGeneric(regs, name)
+	if err != nil {
+		return nil, err
+	}
+	nt.dial = func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", target, timeout)
+	}
+	nt.client = func(conn io.ReadWriter) *opennetzteil.Modbus {
+		return opennetzteil.NewModbusTCP(conn, address, timeout)
+	}
+	return nt, nil
+}
+
+// NewGenericRTU creates a driver for a Modbus RTU device connected
+// via the serial port path. The serial port must be configured.
+func NewGenericRTU(path string, address byte, regs *RegisterMap, name string) (*Generic, error) {
+	nt, err := newGeneric(regs, name)
+	if err != nil {
+		return nil, err
+	}
+	nt.dial = func() (io.ReadWriteCloser, error) {
+		return os.OpenFile(path, os.O_RDWR, 0644)
+	}
+	nt.client = func(conn io.ReadWriter) *opennetzteil.Modbus {
+		return opennetzteil.NewModbusRTU(conn, address, timeout)
+	}
+	return nt, nil
+}
+
+func (nt *Generic) Close() error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		return nil
+	}
+	err := nt.conn.Close()
+	nt.conn = nil
+	return err
+}
+
+// exchange runs f with the Modbus client. The connection is
+// established on demand and closed after transport errors.
+func (nt *Generic) exchange(f func(m *opennetzteil.Modbus) error) error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		conn, err := nt.dial()
+		if err != nil {
+			return opennetzteil.TransportError(err)
+		}
+		nt.conn = conn
+		nt.modbus = nt.client(conn)
+	}
+	err := f(nt.modbus)
+	if errors.Is(err, opennetzteil.ErrTransport) || errors.Is(err, opennetzteil.ErrTimeout) {
+		nt.conn.Close()
+		nt.conn = nil
+	}
+	return err
+}
+
+func words(dataType string) uint16 {
+	switch dataType {
+	case DataUint32, DataInt32, DataFloat32:
+		return 2
+	}
+	return 1
+}
+
+func decode(dataType string, regs []uint16) float64 {
+	var v32 uint32
+	if len(regs) == 2 {
+		v32 = uint32(regs[0])<<16 | uint32(regs[1])
+	}
+	switch dataType {
+	case DataInt16:
+		return float64(int16(regs[0]))
+	case DataUint32:
+		return float64(v32)
+	case DataInt32:
+		return float64(int32(v32))
+	case DataFloat32:
+		return float64(math.Float32frombits(v32))
+	}
+	return float64(regs[0])
+}
+
+func encode(dataType string, val float64) ([]uint16, error) {
+	var v32 uint32
+	switch dataType {
+	case DataUint16:
+		if val < 0 || val > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: %g does not fit into %s", opennetzteil.ErrOutOfRange, val, dataType)
+		}
+		return []uint16{uint16(val)}, nil
+	case DataInt16:
+		if val < math.MinInt16 || val > math.MaxInt16 {
+			return nil, fmt.Errorf("%w: %g does not fit into %s", opennetzteil.ErrOutOfRange, val, dataType)
+		}
+		return []uint16{uint16(int16(val))}, nil
+	case DataUint32:
+		if val < 0 || val > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: %g does not fit into %s", opennetzteil.ErrOutOfRange, val, dataType)
+		}
+		v32 = uint32(val)
+	case DataInt32:
+		if val < math.MinInt32 || val > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: %g does not fit into %s", opennetzteil.ErrOutOfRange, val, dataType)
+		}
+		v32 = uint32(int32(val))
+	case DataFloat32:
+		v32 = math.Float32bits(float32(val))
+	}
+	return []uint16{uint16(v32 >> 16), uint16(v32)}, nil
+}
+
+func (nt *Generic) address(reg *Register, channel int) uint16 {
+	return reg.Address + uint16(channel-1)*nt.regs.Stride
+}
+
+func (nt *Generic) read(reg *Register, channel int) (float64, error) {
+	if reg == nil {
+		return 0, opennetzteil.ErrNotImplemented
+	}
+	var val float64
+	err := nt.exchange(func(m *opennetzteil.Modbus) error {
+		var (
+			addr = nt.address(reg, channel)
+			regs []uint16
+			err  error
+		)
+		switch reg.Type {
+		case TypeCoil:
+			on, err := m.ReadCoil(addr)
+			if on {
+				val = 1
+			}
+			return err
+		case TypeInput:
+			regs, err = m.ReadInputRegisters(addr, words(reg.DataType))
+		default:
+			regs, err = m.ReadRegisters(addr, words(reg.DataType))
+		}
+		if err != nil {
+			return err
+		}
+		val = decode(reg.DataType, regs) * reg.Scale
+		return nil
+	})
+	return val, err
+}
+
+func (nt *Generic) write(reg *Register, channel int, val float64) error {
+	if reg == nil {
+		return opennetzteil.ErrNotImplemented
+	}
+	addr := nt.address(reg, channel)
+	if reg.Type == TypeCoil {
+		return nt.exchange(func(m *opennetzteil.Modbus) error {
+			return m.WriteCoil(addr, val != 0)
+		})
+	}
+	raw := val / reg.Scale
+	if reg.DataType != DataFloat32 {
+		raw = math.Round(raw)
+	}
+	regs, err := encode(reg.DataType, raw)
+	if err != nil {
+		return err
+	}
+	return nt.exchange(func(m *opennetzteil.Modbus) error {
+		if len(regs) == 1 {
+			return m.WriteRegister(addr, regs[0])
+		}
+		return m.WriteRegisters(addr, regs)
+	})
+}
+
+func (nt *Generic) readBool(reg *Register, channel int) (bool, error) {
+	val, err := nt.read(reg, channel)
+	return val != 0, err
+}
+
+func (nt *Generic) writeBool(reg *Register, channel int, enabled bool) error {
+	var val float64
+	if enabled {
+		val = 1
+	}
+	return nt.write(reg, channel, val)
+}
+
+// Ping reads the first configured register of the measured voltage,
+// the output, and the voltage setpoint.
+func (nt *Generic) Ping() error {
+	for _, reg := range []*Register{nt.regs.Voltage, nt.regs.Output, nt.regs.VoltageSetpoint} {
+		if reg != nil {
+			_, err := nt.read(reg, 1)
+			return err
+		}
+	}
+	return nil
+}
+
+// Probe checks the connection by reading the first configured register.
+func (nt *Generic) Probe() error {
+	if err := nt.Ping(); err != nil {
+		return err
+	}
+	nt.SetIdent(nt.regs.Ident)
+	return nil
+}
+
+func (nt *Generic) Status() (interface{}, error) {
+	return nil, opennetzteil.ErrNotImplemented
+}
+
+func (nt *Generic) GetMaster() (bool, error) {
+	if nt.regs.Master != nil {
+		return nt.readBool(nt.regs.Master, 1)
+	}
+	for ch := 1; ch <= nt.regs.Channels; ch++ {
+		out, err := nt.GetOut(ch)
+		if err != nil {
+			return false, err
+		}
+		if out {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (nt *Generic) SetMaster(enabled bool) error {
+	if nt.regs.Master != nil {
+		return nt.writeBool(nt.regs.Master, 1, enabled)
+	}
+	for ch := 1; ch <= nt.regs.Channels; ch++ {
+		if err := nt.SetOut(ch, enabled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nt *Generic) SetBeep(enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+func (nt *Generic) Capabilities() []string {
+	var caps []string
+	if nt.regs.Master != nil || nt.regs.Output != nil {
+		caps = append(caps, opennetzteil.CapabilityMaster)
+	}
+	if nt.regs.OCP != nil {
+		caps = append(caps, opennetzteil.CapabilityOCP)
+	}
+	if nt.regs.OVP != nil {
+		caps = append(caps, opennetzteil.CapabilityOVP)
+	}
+	return caps
+}
+
+func (nt *Generic) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return opennetzteil.Rating{
+		MaxVoltage: nt.regs.MaxVoltage,
+		MaxCurrent: nt.regs.MaxCurrent,
+		MaxPower:   nt.regs.MaxPower,
+	}, nil
+}
+
+func (nt *Generic) GetMode(channel int) (string, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return "", err
+	}
+	cc, err := nt.readBool(nt.regs.Mode, channel)
+	if err != nil {
+		return "", err
+	}
+	if cc {
+		return opennetzteil.ModeCC, nil
+	}
+	return opennetzteil.ModeCV, nil
+}
+
+// GetProtectionTripped reports false if the
+// protection register is missing.
+func (nt *Generic) GetProtectionTripped(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return false, err
+	}
+	if nt.regs.Protection == nil {
+		return false, nil
+	}
+	return nt.readBool(nt.regs.Protection, channel)
+}
+
+func (nt *Generic) GetChannels() (int, error) {
+	return nt.regs.Channels, nil
+}
+
+func (nt *Generic) GetCurrent(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return 0, err
+	}
+	return nt.read(nt.regs.Current, channel)
+}
+
+func (nt *Generic) SetCurrent(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return err
+	}
+	if max := nt.regs.MaxCurrent; current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nt.write(nt.regs.CurrentSetpoint, channel, current)
+}
+
+func (nt *Generic) GetVoltage(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return 0, err
+	}
+	return nt.read(nt.regs.Voltage, channel)
+}
+
+func (nt *Generic) SetVoltage(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return err
+	}
+	if max := nt.regs.MaxVoltage; voltage < 0 || voltage > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nt.write(nt.regs.VoltageSetpoint, channel, voltage)
+}
+
+func (nt *Generic) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return 0, err
+	}
+	return nt.read(nt.regs.VoltageSetpoint, channel)
+}
+
+func (nt *Generic) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return 0, err
+	}
+	return nt.read(nt.regs.CurrentSetpoint, channel)
+}
+
+func (nt *Generic) GetOut(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return false, err
+	}
+	return nt.readBool(nt.regs.Output, channel)
+}
+
+func (nt *Generic) SetOut(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return err
+	}
+	return nt.writeBool(nt.regs.Output, channel, enabled)
+}
+
+func (nt *Generic) GetOCP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return false, err
+	}
+	return nt.readBool(nt.regs.OCP, channel)
+}
+
+func (nt *Generic) SetOCP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return err
+	}
+	return nt.writeBool(nt.regs.OCP, channel, enabled)
+}
+
+func (nt *Generic) GetOVP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return false, err
+	}
+	return nt.readBool(nt.regs.OVP, channel)
+}
+
+func (nt *Generic) SetOVP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.regs.Channels); err != nil {
+		return err
+	}
+	return nt.writeBool(nt.regs.OVP, channel, enabled)
+}
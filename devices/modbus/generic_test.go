@@ -0,0 +1,48 @@
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewGenericKeepsRegisterMap(t *testing.T) {
+	regs := &RegisterMap{
+		MaxVoltage:      30,
+		MaxCurrent:      5,
+		VoltageSetpoint: &Register{Address: 0x10},
+		Voltage:         &Register{Address: 0x20, Type: TypeInput, Scale: 0.01},
+	}
+	orig := regs.Copy()
+
+	nt, err := NewGenericTCP("127.0.0.1:502", 1, regs, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(regs, orig) {
+		t.Errorf("register map changed to %+v", regs)
+	}
+	if nt.regs.Channels != 1 || nt.regs.VoltageSetpoint.Type != TypeHolding || nt.regs.VoltageSetpoint.Scale != 1 {
+		t.Errorf("defaults not set: %+v, %+v", nt.regs, nt.regs.VoltageSetpoint)
+	}
+	if nt.regs.Voltage == regs.Voltage {
+		t.Error("registers are shared with the configuration")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		regs RegisterMap
+	}{
+		{"no ratings", RegisterMap{}},
+		{"no stride", RegisterMap{MaxVoltage: 30, MaxCurrent: 5, Channels: 2}},
+		{"writable input", RegisterMap{MaxVoltage: 30, MaxCurrent: 5, Output: &Register{Type: TypeInput}}},
+		{"invalid type", RegisterMap{MaxVoltage: 30, MaxCurrent: 5, Voltage: &Register{Type: "foo"}}},
+		{"invalid data type", RegisterMap{MaxVoltage: 30, MaxCurrent: 5, Voltage: &Register{DataType: "int8"}}},
+	}
+	for _, tc := range tests {
+		if err := tc.regs.Validate(); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
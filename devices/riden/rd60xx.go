@@ -0,0 +1,431 @@
+package riden
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultAddress is the factory default Modbus address.
+const DefaultAddress = 1
+
+const timeout = 500 * time.Millisecond
+
+// Holding registers of the RD60xx series.
+const (
+	regID          = 0
+	regSerial      = 1 // two registers, high word first
+	regFirmware    = 3
+	regTempSign    = 4
+	regTemp        = 5
+	regVoltageSet  = 8
+	regCurrentSet  = 9
+	regVoltageOut  = 10
+	regCurrentOut  = 11
+	regPowerOut    = 12 // two registers, high word first
+	regVoltageIn   = 14
+	regProtection  = 16
+	regMode        = 17
+	regOutput      = 18
+	regOVPLevel    = 82
+	regOCPLevel    = 83
+	statusRegStart = regTempSign
+	statusRegCount = regOutput - regTempSign + 1
+)
+
+// Values of the protection register.
+const (
+	ProtectionNone = ""
+	ProtectionOVP  = "OVP"
+	ProtectionOCP  = "OCP"
+)
+
+var protections = map[uint16]string{
+	0: ProtectionNone,
+	1: ProtectionOVP,
+	2: ProtectionOCP,
+}
+
+type model struct {
+	rating opennetzteil.Rating
+	// currentScale is the number of register
+	// units per ampere of the current registers.
+	currentScale float64
+}
+
+// The ID register holds the model number times ten plus a variant
+// digit, e.g. 60062 for the RD6006. Other variants, such as the
+// RD6006P (60065), use different register scales and are rejected.
+var models = map[uint16]model{
+	60062: {opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 6, MaxPower: 360}, 1000},
+	60121: {opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 12, MaxPower: 720}, 100},
+	60181: {opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 18, MaxPower: 1080}, 100},
+}
+
+// The voltage registers hold centivolts.
+const voltageScale = 100
+
+// RD60xx implements the Riden RD6006, RD6012, and RD6018. They
+// are connected via USB serial and speak Modbus RTU. The serial
+// line must be configured to the baudrate of the device.
+type RD60xx struct {
+	opennetzteil.NetzteilBase
+	path   string
+	file   *os.File
+	modbus *opennetzteil.Modbus
+	model  model
+}
+
+type Status struct {
+	ChannelMode string
+	Output      bool
+	// Protection is the protection which tripped, if any.
+	Protection   string
+	Temperature  float64
+	InputVoltage float64
+}
+
+func NewRD60xx(path string, address byte, name string) (*RD60xx, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &RD60xx{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		path:         path,
+		file:         file,
+		modbus:       opennetzteil.NewModbusRTU(file, address, timeout),
+	}, nil
+}
+
+func (nt *RD60xx) Close() error {
+	return nt.file.Close()
+}
+
+func (nt *RD60xx) reopenHandleIfNeeded(err error) error {
+	// The handle must be renewed if the USB serial
+	// adapter was reconnected.
+	if errors.Is(err, syscall.EIO) {
+		file, err := os.OpenFile(nt.path, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		nt.file.Close()
+		nt.file = file
+		nt.modbus.SetHandle(file)
+	}
+	return nil
+}
+
+func (nt *RD60xx) readRegisters(addr, count uint16) ([]uint16, error) {
+	regs, err := nt.modbus.ReadRegisters(addr, count)
+	if err != nil {
+		if rerr := nt.reopenHandleIfNeeded(err); rerr != nil {
+			return nil, rerr
+		}
+		return nil, err
+	}
+	return regs, nil
+}
+
+func (nt *RD60xx) readRegister(addr uint16) (uint16, error) {
+	regs, err := nt.readRegisters(addr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return regs[0], nil
+}
+
+func (nt *RD60xx) writeRegister(addr, value uint16) error {
+	err := nt.modbus.WriteRegister(addr, value)
+	if err != nil {
+		if rerr := nt.reopenHandleIfNeeded(err); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+func (nt *RD60xx) Ping() error {
+	_, err := nt.readRegisters(regID, 1)
+	return err
+}
+
+func (nt *RD60xx) Probe() error {
+	regs, err := nt.readRegisters(regID, regFirmware+1)
+	if err != nil {
+		return err
+	}
+	m, ok := models[regs[regID]]
+	if !ok {
+		return fmt.Errorf("unsupported model: %d", regs[regID])
+	}
+	nt.model = m
+	serial := uint32(regs[regSerial])<<16 | uint32(regs[regSerial+1])
+	nt.SetIdent(fmt.Sprintf("Riden RD%d, SN %08d, FW %.2f", regs[regID]/10, serial, float64(regs[regFirmware])/100))
+	return nil
+}
+
+func (nt *RD60xx) Status() (interface{}, error) {
+	regs, err := nt.readRegisters(statusRegStart, statusRegCount)
+	if err != nil {
+		return nil, err
+	}
+	reg := func(addr uint16) uint16 {
+		return regs[addr-statusRegStart]
+	}
+	status := Status{
+		ChannelMode:  opennetzteil.ModeCV,
+		Output:       reg(regOutput) != 0,
+		Protection:   protections[reg(regProtection)],
+		Temperature:  float64(reg(regTemp)),
+		InputVoltage: float64(reg(regVoltageIn)) / voltageScale,
+	}
+	if reg(regTempSign) != 0 {
+		status.Temperature = -status.Temperature
+	}
+	if reg(regMode) != 0 {
+		status.ChannelMode = opennetzteil.ModeCC
+	}
+	return status, nil
+}
+
+// GetMaster returns the state of the output;
+// the devices have a single output.
+func (nt *RD60xx) GetMaster() (bool, error) {
+	return nt.GetOut(1)
+}
+
+// SetMaster switches the output.
+func (nt *RD60xx) SetMaster(enabled bool) error {
+	return nt.SetOut(1, enabled)
+}
+
+func (nt *RD60xx) SetBeep(enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+func (nt *RD60xx) Capabilities() []string {
+	return []string{
+		opennetzteil.CapabilityStatus,
+		opennetzteil.CapabilityMaster,
+	}
+}
+
+func (nt *RD60xx) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.model.rating, nil
+}
+
+func (nt *RD60xx) GetMode(channel int) (string, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return "", err
+	}
+	mode, err := nt.readRegister(regMode)
+	if err != nil {
+		return "", err
+	}
+	if mode != 0 {
+		return opennetzteil.ModeCC, nil
+	}
+	return opennetzteil.ModeCV, nil
+}
+
+// GetProtectionTripped reports whether the OVP or OCP tripped.
+func (nt *RD60xx) GetProtectionTripped(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
+	prot, err := nt.readRegister(regProtection)
+	if err != nil {
+		return false, err
+	}
+	return prot != 0, nil
+}
+
+func (nt *RD60xx) GetChannels() (int, error) {
+	return 1, nil
+}
+
+func (nt *RD60xx) GetCurrent(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regCurrentOut)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / nt.model.currentScale, nil
+}
+
+func (nt *RD60xx) checkCurrent(current float64) error {
+	if max := nt.model.rating.MaxCurrent; current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nil
+}
+
+func (nt *RD60xx) checkVoltage(voltage float64) error {
+	if max := nt.model.rating.MaxVoltage; voltage < 0 || voltage > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nil
+}
+
+func (nt *RD60xx) SetCurrent(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if err := nt.checkCurrent(current); err != nil {
+		return err
+	}
+	return nt.writeRegister(regCurrentSet, uint16(math.Round(current*nt.model.currentScale)))
+}
+
+func (nt *RD60xx) GetVoltage(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regVoltageOut)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / voltageScale, nil
+}
+
+func (nt *RD60xx) SetVoltage(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if err := nt.checkVoltage(voltage); err != nil {
+		return err
+	}
+	return nt.writeRegister(regVoltageSet, uint16(math.Round(voltage*voltageScale)))
+}
+
+func (nt *RD60xx) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regVoltageSet)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / voltageScale, nil
+}
+
+func (nt *RD60xx) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regCurrentSet)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / nt.model.currentScale, nil
+}
+
+// GetPower returns the measured output power.
+func (nt *RD60xx) GetPower(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	regs, err := nt.readRegisters(regPowerOut, 2)
+	if err != nil {
+		return 0, err
+	}
+	return float64(uint32(regs[0])<<16|uint32(regs[1])) / 100, nil
+}
+
+func (nt *RD60xx) GetOut(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
+	val, err := nt.readRegister(regOutput)
+	if err != nil {
+		return false, err
+	}
+	return val != 0, nil
+}
+
+func (nt *RD60xx) SetOut(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	var val uint16
+	if enabled {
+		val = 1
+	}
+	return nt.writeRegister(regOutput, val)
+}
+
+// The OCP and OVP are always enabled; only their levels can be set.
+
+func (nt *RD60xx) GetOCP(channel int) (bool, error) {
+	return false, opennetzteil.ErrNotImplemented
+}
+
+func (nt *RD60xx) SetOCP(channel int, enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+// GetOCPLevel returns the current at which the OCP trips.
+func (nt *RD60xx) GetOCPLevel(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regOCPLevel)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / nt.model.currentScale, nil
+}
+
+// SetOCPLevel sets the current at which the OCP trips.
+func (nt *RD60xx) SetOCPLevel(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if err := nt.checkCurrent(current); err != nil {
+		return err
+	}
+	return nt.writeRegister(regOCPLevel, uint16(math.Round(current*nt.model.currentScale)))
+}
+
+func (nt *RD60xx) GetOVP(channel int) (bool, error) {
+	return false, opennetzteil.ErrNotImplemented
+}
+
+func (nt *RD60xx) SetOVP(channel int, enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+// GetOVPLevel returns the voltage at which the OVP trips.
+func (nt *RD60xx) GetOVPLevel(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	val, err := nt.readRegister(regOVPLevel)
+	if err != nil {
+		return 0, err
+	}
+	return float64(val) / voltageScale, nil
+}
+
+// SetOVPLevel sets the voltage at which the OVP trips.
+func (nt *RD60xx) SetOVPLevel(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if err := nt.checkVoltage(voltage); err != nil {
+		return err
+	}
+	return nt.writeRegister(regOVPLevel, uint16(math.Round(voltage*voltageScale)))
+}
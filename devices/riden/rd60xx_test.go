@@ -0,0 +1,125 @@
+package riden
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// fakeRD60xx serves the holding registers regs via Modbus RTU.
+// Only the functions used by the driver are implemented.
+type fakeRD60xx struct {
+	mutex sync.Mutex
+	regs  map[uint16]uint16
+}
+
+func (f *fakeRD60xx) serve(rw io.ReadWriter) {
+	for {
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(rw, req); err != nil {
+			return
+		}
+		var (
+			addr  = binary.BigEndian.Uint16(req[2:])
+			value = binary.BigEndian.Uint16(req[4:])
+			resp  = req[:6]
+		)
+		f.mutex.Lock()
+		switch req[1] {
+		case 0x03:
+			resp = []byte{req[0], req[1], byte(2 * value)}
+			for i := uint16(0); i < value; i++ {
+				resp = append(resp, byte(f.regs[addr+i]>>8), byte(f.regs[addr+i]))
+			}
+		case 0x06:
+			f.regs[addr] = value
+		}
+		f.mutex.Unlock()
+		crc := opennetzteil.CRC16(resp)
+		rw.Write(append(resp, byte(crc), byte(crc>>8)))
+	}
+}
+
+func (f *fakeRD60xx) reg(addr uint16) uint16 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.regs[addr]
+}
+
+func newTestRD60xx(t *testing.T, regs map[uint16]uint16) (*RD60xx, *fakeRD60xx) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	f := &fakeRD60xx{regs: regs}
+	go f.serve(server)
+	return &RD60xx{modbus: opennetzteil.NewModbusRTU(client, DefaultAddress, timeout)}, f
+}
+
+func TestRD60xxModels(t *testing.T) {
+	tests := []struct {
+		id         uint16
+		name       string
+		maxCurrent float64
+		// current is the value of the current register 1234.
+		current float64
+		// currentReg is the register value of 2.5 A.
+		currentReg uint16
+	}{
+		{60062, "RD6006", 6, 1.234, 2500},
+		{60121, "RD6012", 12, 12.34, 250},
+		{60181, "RD6018", 18, 12.34, 250},
+	}
+	for _, tc := range tests {
+		nt, f := newTestRD60xx(t, map[uint16]uint16{
+			regID:         tc.id,
+			regFirmware:   128,
+			regVoltageOut: 1234,
+			regCurrentOut: 1234,
+		})
+		if err := nt.Probe(); err != nil {
+			t.Fatalf("%d: %s", tc.id, err)
+		}
+		if ident, _ := nt.GetIdent(); !strings.HasPrefix(ident, "Riden "+tc.name+",") {
+			t.Errorf("%d: got ident %q", tc.id, ident)
+		}
+		if rating, _ := nt.GetRating(1); rating.MaxCurrent != tc.maxCurrent {
+			t.Errorf("%d: got max current %g A, want %g A", tc.id, rating.MaxCurrent, tc.maxCurrent)
+		}
+		if current, err := nt.GetCurrent(1); err != nil || math.Abs(current-tc.current) > 1e-9 {
+			t.Errorf("%d: GetCurrent: got %g, %v; want %g", tc.id, current, err, tc.current)
+		}
+		if voltage, err := nt.GetVoltage(1); err != nil || math.Abs(voltage-12.34) > 1e-9 {
+			t.Errorf("%d: GetVoltage: got %g, %v; want 12.34", tc.id, voltage, err)
+		}
+		if err := nt.SetCurrent(1, 2.5); err != nil {
+			t.Errorf("%d: SetCurrent: %s", tc.id, err)
+		}
+		if reg := f.reg(regCurrentSet); reg != tc.currentReg {
+			t.Errorf("%d: current register is %d, want %d", tc.id, reg, tc.currentReg)
+		}
+		if err := nt.SetVoltage(1, 12.5); err != nil {
+			t.Errorf("%d: SetVoltage: %s", tc.id, err)
+		}
+		if reg := f.reg(regVoltageSet); reg != 1250 {
+			t.Errorf("%d: voltage register is %d, want 1250", tc.id, reg)
+		}
+	}
+}
+
+func TestRD60xxUnknownVariant(t *testing.T) {
+	// P variants and unsupported models, such as the RD6024.
+	for _, id := range []uint16{60065, 60125, 60240} {
+		nt, _ := newTestRD60xx(t, map[uint16]uint16{regID: id})
+		if err := nt.Probe(); err == nil {
+			t.Errorf("model %d accepted", id)
+		}
+	}
+}
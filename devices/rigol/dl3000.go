@@ -0,0 +1,156 @@
+package rigol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+type loadRating struct {
+	maxVoltage float64
+	maxCurrent float64
+	maxPower   float64
+}
+
+// The A variants share the ratings of the base models.
+var loadModels = map[string]loadRating{
+	"DL3021": {maxVoltage: 150, maxCurrent: 40, maxPower: 200},
+	"DL3031": {maxVoltage: 150, maxCurrent: 60, maxPower: 350},
+}
+
+// Function names of the :SOUR:FUNC command.
+var loadFunctions = map[string]string{
+	opennetzteil.ModeCC: "CURR",
+	opennetzteil.ModeCV: "VOLT",
+	opennetzteil.ModeCR: "RES",
+	opennetzteil.ModeCP: "POW",
+}
+
+// DL3000 implements the Rigol DL3000 series of electronic loads.
+type DL3000 struct {
+	conn
+	rating loadRating
+}
+
+// NewDL3000 creates a driver for a device reachable
+// via LAN; target is host:port.
+func NewDL3000(target, name string) *DL3000 {
+	l := &DL3000{}
+	l.init(target, name)
+	return l
+}
+
+// NewDL3000USBTMC creates a driver for a device
+// connected via the usbtmc kernel driver.
+func NewDL3000USBTMC(path, name string) (*DL3000, error) {
+	l := &DL3000{}
+	if err := l.openUSBTMC(path, name); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Probe identifies the model. The response of *IDN?
+// looks like: RIGOL TECHNOLOGIES,DL3021,DL3AXXXXXXXXX,00.01.02
+func (l *DL3000) Probe() error {
+	ident, err := l.request("*IDN?")
+	if err != nil {
+		return err
+	}
+	idn := opennetzteil.ParseIdent(ident)
+	r, ok := loadModels[strings.TrimSuffix(idn.Model, "A")]
+	if !ok {
+		return fmt.Errorf("unsupported model: %s", idn.Model)
+	}
+	l.rating = r
+	l.SetIdent(ident)
+	return nil
+}
+
+// GetMode queries the regulation mode. The response is
+// either the short or the long form, e.g. CURR or CURRENT.
+func (l *DL3000) GetMode() (string, error) {
+	resp, err := l.request(":SOUR:FUNC?")
+	if err != nil {
+		return "", err
+	}
+	resp = strings.ToUpper(resp)
+	for mode, fn := range loadFunctions {
+		if strings.HasPrefix(resp, fn) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unexpected response to ':SOUR:FUNC?': %s", resp)
+}
+
+func (l *DL3000) SetMode(mode string) error {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return err
+	}
+	return l.send(":SOUR:FUNC " + loadFunctions[mode])
+}
+
+func (l *DL3000) GetSetpoint(mode string) (float64, error) {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return 0, err
+	}
+	return l.requestFloat(fmt.Sprintf(":SOUR:%s:LEV?", loadFunctions[mode]))
+}
+
+func (l *DL3000) checkSetpoint(mode string, value float64) error {
+	var (
+		max  float64
+		unit string
+	)
+	switch mode {
+	case opennetzteil.ModeCC:
+		max, unit = l.rating.maxCurrent, "A"
+	case opennetzteil.ModeCV:
+		max, unit = l.rating.maxVoltage, "V"
+	case opennetzteil.ModeCP:
+		max, unit = l.rating.maxPower, "W"
+	case opennetzteil.ModeCR:
+		// The resistance range depends on the selected
+		// range of the device; leave the check to it.
+		if value < 0 {
+			return fmt.Errorf("%w: resistance %.3f Ω; must not be negative", opennetzteil.ErrOutOfRange, value)
+		}
+		return nil
+	}
+	if value < 0 || value > max {
+		return fmt.Errorf("%w: %s setpoint %.3f %s; must be 0-%g %s", opennetzteil.ErrOutOfRange, mode, value, unit, max, unit)
+	}
+	return nil
+}
+
+func (l *DL3000) SetSetpoint(mode string, value float64) error {
+	if err := opennetzteil.CheckLoadMode(mode); err != nil {
+		return err
+	}
+	if err := l.checkSetpoint(mode, value); err != nil {
+		return err
+	}
+	return l.send(fmt.Sprintf(":SOUR:%s:LEV %s", loadFunctions[mode], strconv.FormatFloat(value, 'f', 3, 64)))
+}
+
+func (l *DL3000) GetInput() (bool, error) {
+	return l.requestBool(":SOUR:INP:STAT?")
+}
+
+func (l *DL3000) SetInput(enabled bool) error {
+	return l.send(":SOUR:INP:STAT " + onOff(enabled))
+}
+
+func (l *DL3000) GetVoltage() (float64, error) {
+	return l.requestFloat(":MEAS:VOLT?")
+}
+
+func (l *DL3000) GetCurrent() (float64, error) {
+	return l.requestFloat(":MEAS:CURR?")
+}
+
+func (l *DL3000) GetPower() (float64, error) {
+	return l.requestFloat(":MEAS:POW?")
+}
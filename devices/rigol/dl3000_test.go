@@ -0,0 +1,35 @@
+package rigol
+
+import "testing"
+
+func TestDL3000Probe(t *testing.T) {
+	tests := []struct {
+		ident    string
+		maxPower float64
+		err      bool
+	}{
+		{"RIGOL TECHNOLOGIES,DL3021,DL3A000000001,00.01.02", 200, false},
+		{"RIGOL TECHNOLOGIES,DL3021A,DL3A000000002,00.01.05", 200, false},
+		{"RIGOL TECHNOLOGIES, DL3031A ,DL3B000000001,00.01.05", 350, false},
+		{"RIGOL TECHNOLOGIES,DP832,DP8A000000001,00.01.14", 0, true},
+		{"garbage", 0, true},
+	}
+	for _, tc := range tests {
+		f := newFakeDP800(t, map[string]string{"*IDN?": tc.ident})
+		l := NewDL3000(f.addr, "")
+		err := l.Probe()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.ident)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.ident, err)
+			continue
+		}
+		if l.rating.maxPower != tc.maxPower {
+			t.Errorf("%s: got %g W, want %g W", tc.ident, l.rating.maxPower, tc.maxPower)
+		}
+	}
+}
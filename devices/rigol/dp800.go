@@ -0,0 +1,503 @@
+package rigol
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultPort is the SCPI port of the LAN interface.
+const DefaultPort = "5555"
+
+// Pairing modes of the DP832 reported via Status.
+const (
+	PairingOff      = "OFF"
+	PairingSeries   = "SER"
+	PairingParallel = "PAR"
+)
+
+type model struct {
+	ratings []opennetzteil.Rating
+	// tracking lists the channels which support tracking.
+	tracking []int
+	// pairing is true if the channels 1 and 2 can be
+	// connected in series or in parallel.
+	pairing bool
+}
+
+// The A variants share the ratings of the base models.
+var models = map[string]model{
+	"DP811": {
+		ratings: []opennetzteil.Rating{{MaxVoltage: 40, MaxCurrent: 10, MaxPower: 200}},
+	},
+	"DP821": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 60, MaxCurrent: 1, MaxPower: 60},
+			{MaxVoltage: 8, MaxCurrent: 10, MaxPower: 80},
+		},
+	},
+	"DP831": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 8, MaxCurrent: 5, MaxPower: 40},
+			{MaxVoltage: 30, MaxCurrent: 2, MaxPower: 60},
+			{MaxVoltage: 30, MaxCurrent: 2, MaxPower: 60},
+		},
+		tracking: []int{2, 3},
+	},
+	"DP832": {
+		ratings: []opennetzteil.Rating{
+			{MaxVoltage: 30, MaxCurrent: 3, MaxPower: 90},
+			{MaxVoltage: 30, MaxCurrent: 3, MaxPower: 90},
+			{MaxVoltage: 5, MaxCurrent: 3, MaxPower: 15},
+		},
+		tracking: []int{1, 2},
+		pairing:  true,
+	},
+}
+
+// conn is the SCPI connection shared by the Rigol drivers. The device
+// is either connected via LAN or via USBTMC, e.g. /dev/usbtmc0.
+type conn struct {
+	opennetzteil.NetzteilBase
+	target string
+	file   *os.File
+}
+
+func (nt *conn) init(target, name string) {
+	nt.Name = name
+	nt.target = target
+}
+
+func (nt *conn) openUSBTMC(path, name string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	nt.Name = name
+	nt.file = file
+	return nil
+}
+
+// DP800 implements the Rigol DP800 series.
+type DP800 struct {
+	conn
+	model model
+}
+
+type ChannelStatus struct {
+	ChannelMode string
+	Output      bool
+	// Tracking is only present for channels which support it.
+	Tracking *bool `json:",omitempty"`
+}
+
+type Status struct {
+	// Pairing is one of PairingOff, PairingSeries, or
+	// PairingParallel; it is empty if not supported.
+	Pairing  string `json:",omitempty"`
+	Channels []ChannelStatus
+}
+
+// NewDP800 creates a driver for a device reachable via
+// LAN; target is host:port.
+func NewDP800(target, name string) *DP800 {
+	nt := &DP800{}
+	nt.init(target, name)
+	return nt
+}
+
+// NewDP800USBTMC creates a driver for a device
+// connected via the usbtmc kernel driver.
+func NewDP800USBTMC(path, name string) (*DP800, error) {
+	nt := &DP800{}
+	if err := nt.openUSBTMC(path, name); err != nil {
+		return nil, err
+	}
+	return nt, nil
+}
+
+func (nt *conn) Close() error {
+	if nt.file == nil {
+		return nil
+	}
+	return nt.file.Close()
+}
+
+func (nt *conn) request(cmd string) (string, error) {
+	var (
+		resp []byte
+		err  error
+	)
+	if nt.file != nil {
+		resp, err = nt.RequestLine(nt.file, []byte(cmd))
+	} else {
+		resp, err = nt.TCPRequest(nt.target, cmd)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(resp)), nil
+}
+
+func (nt *conn) send(cmds ...string) error {
+	if nt.file != nil {
+		for _, cmd := range cmds {
+			if err := nt.SendCommandLine(nt.file, []byte(cmd)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nt.TCPSendBatched(nt.target, cmds)
+}
+
+func (nt *conn) Ping() error {
+	_, err := nt.request("*IDN?")
+	return err
+}
+
+func (nt *conn) requestBool(cmd string) (bool, error) {
+	resp, err := nt.request(cmd)
+	if err != nil {
+		return false, err
+	}
+	switch resp {
+	case "ON", "YES", "1":
+		return true, nil
+	case "OFF", "NO", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected response to '%s': %s", cmd, resp)
+}
+
+func (nt *conn) requestFloat(cmd string) (float64, error) {
+	resp, err := nt.request(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp, 64)
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func (nt *DP800) checkChannel(channel int) error {
+	return opennetzteil.CheckChannel(channel, len(nt.model.ratings))
+}
+
+// Probe identifies the model. The response of *IDN?
+// looks like: RIGOL TECHNOLOGIES,DP832,DP8XXXXXXXXX,00.01.14
+func (nt *DP800) Probe() error {
+	ident, err := nt.request("*IDN?")
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(ident, ",")
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected identification: %s", ident)
+	}
+	m, ok := models[strings.TrimSuffix(fields[1], "A")]
+	if !ok {
+		return fmt.Errorf("unsupported model: %s", fields[1])
+	}
+	nt.model = m
+	nt.SetIdent(ident)
+	return nil
+}
+
+func (nt *DP800) Status() (interface{}, error) {
+	var status Status
+	if nt.model.pairing {
+		resp, err := nt.request(":OUTP:PAIR?")
+		if err != nil {
+			return nil, err
+		}
+		status.Pairing = resp
+	}
+	for ch := 1; ch <= len(nt.model.ratings); ch++ {
+		var (
+			cs  ChannelStatus
+			err error
+		)
+		if cs.ChannelMode, err = nt.GetMode(ch); err != nil {
+			return nil, err
+		}
+		if cs.Output, err = nt.GetOut(ch); err != nil {
+			return nil, err
+		}
+		for _, t := range nt.model.tracking {
+			if t != ch {
+				continue
+			}
+			tracking, err := nt.requestBool(fmt.Sprintf(":OUTP:TRAC? CH%d", ch))
+			if err != nil {
+				return nil, err
+			}
+			cs.Tracking = &tracking
+		}
+		status.Channels = append(status.Channels, cs)
+	}
+	return status, nil
+}
+
+// GetMaster reports whether any output is enabled;
+// the DP800 series has no master output.
+func (nt *DP800) GetMaster() (bool, error) {
+	for ch := 1; ch <= len(nt.model.ratings); ch++ {
+		out, err := nt.GetOut(ch)
+		if err != nil {
+			return false, err
+		}
+		if out {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetMaster switches all outputs.
+func (nt *DP800) SetMaster(enabled bool) error {
+	var cmds []string
+	for ch := 1; ch <= len(nt.model.ratings); ch++ {
+		cmds = append(cmds, fmt.Sprintf(":OUTP CH%d,%s", ch, onOff(enabled)))
+	}
+	return nt.send(cmds...)
+}
+
+func (nt *DP800) SetBeep(enabled bool) error {
+	return nt.send(":SYST:BEEP " + onOff(enabled))
+}
+
+func (nt *DP800) Capabilities() []string {
+	return []string{
+		opennetzteil.CapabilityStatus,
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityBeep,
+		opennetzteil.CapabilityOCP,
+		opennetzteil.CapabilityOVP,
+	}
+}
+
+func (nt *DP800) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.model.ratings[channel-1], nil
+}
+
+// GetMode returns ModeCV, ModeCC, or "UR" if the
+// channel is unregulated.
+func (nt *DP800) GetMode(channel int) (string, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return "", err
+	}
+	return nt.request(fmt.Sprintf(":OUTP:MODE? CH%d", channel))
+}
+
+func (nt *DP800) GetProtectionTripped(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	ocp, err := nt.requestBool(fmt.Sprintf(":OUTP:OCP:QUES? CH%d", channel))
+	if err != nil {
+		return false, err
+	}
+	ovp, err := nt.requestBool(fmt.Sprintf(":OUTP:OVP:QUES? CH%d", channel))
+	if err != nil {
+		return false, err
+	}
+	return ocp || ovp, nil
+}
+
+func (nt *DP800) GetChannels() (int, error) {
+	return len(nt.model.ratings), nil
+}
+
+// Measure returns the measured voltage, current, and power of channel.
+func (nt *DP800) Measure(channel int) (voltage, current, power float64, err error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, 0, 0, err
+	}
+	cmd := fmt.Sprintf(":MEAS:ALL? CH%d", channel)
+	resp, err := nt.request(cmd)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Split(resp, ",")
+	if len(fields) != 3 {
+		return 0, 0, 0, fmt.Errorf("unexpected response to '%s': %s", cmd, resp)
+	}
+	var vals [3]float64
+	for i, f := range fields {
+		if vals[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+func (nt *DP800) GetCurrent(channel int) (float64, error) {
+	_, current, _, err := nt.Measure(channel)
+	return current, err
+}
+
+func (nt *DP800) checkCurrent(channel int, current float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	max := nt.model.ratings[channel-1].MaxCurrent
+	if current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nil
+}
+
+// checkVoltage compares the magnitude of voltage as
+// channel 3 of the DP831 is a negative output.
+func (nt *DP800) checkVoltage(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	max := nt.model.ratings[channel-1].MaxVoltage
+	if math.Abs(voltage) > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nil
+}
+
+func (nt *DP800) SetCurrent(channel int, current float64) error {
+	if err := nt.checkCurrent(channel, current); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":SOUR%d:CURR %.3f", channel, current))
+}
+
+func (nt *DP800) GetVoltage(channel int) (float64, error) {
+	voltage, _, _, err := nt.Measure(channel)
+	return voltage, err
+}
+
+func (nt *DP800) SetVoltage(channel int, voltage float64) error {
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":APPL CH%d,%.3f", channel, voltage))
+}
+
+func (nt *DP800) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf(":SOUR%d:VOLT?", channel))
+}
+
+func (nt *DP800) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf(":SOUR%d:CURR?", channel))
+}
+
+// Apply sets voltage and current of channel with a single command.
+func (nt *DP800) Apply(channel int, voltage, current float64) error {
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	if err := nt.checkCurrent(channel, current); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":APPL CH%d,%.3f,%.3f", channel, voltage, current))
+}
+
+func (nt *DP800) GetOut(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	return nt.requestBool(fmt.Sprintf(":OUTP? CH%d", channel))
+}
+
+func (nt *DP800) SetOut(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":OUTP CH%d,%s", channel, onOff(enabled)))
+}
+
+func (nt *DP800) GetOCP(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	return nt.requestBool(fmt.Sprintf(":OUTP:OCP? CH%d", channel))
+}
+
+func (nt *DP800) SetOCP(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":OUTP:OCP CH%d,%s", channel, onOff(enabled)))
+}
+
+// GetOCPLevel returns the current at which the OCP trips.
+func (nt *DP800) GetOCPLevel(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf(":OUTP:OCP:VAL? CH%d", channel))
+}
+
+// SetOCPLevel sets the current at which the OCP trips.
+func (nt *DP800) SetOCPLevel(channel int, current float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":OUTP:OCP:VAL CH%d,%.3f", channel, current))
+}
+
+func (nt *DP800) GetOVP(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	return nt.requestBool(fmt.Sprintf(":OUTP:OVP? CH%d", channel))
+}
+
+func (nt *DP800) SetOVP(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":OUTP:OVP CH%d,%s", channel, onOff(enabled)))
+}
+
+// GetOVPLevel returns the voltage at which the OVP trips.
+func (nt *DP800) GetOVPLevel(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf(":OUTP:OVP:VAL? CH%d", channel))
+}
+
+// SetOVPLevel sets the voltage at which the OVP trips.
+func (nt *DP800) SetOVPLevel(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf(":OUTP:OVP:VAL CH%d,%.3f", channel, voltage))
+}
+
+// ClearProtection clears a tripped OCP or OVP of channel.
+func (nt *DP800) ClearProtection(channel int) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(
+		fmt.Sprintf(":OUTP:OCP:CLEAR CH%d", channel),
+		fmt.Sprintf(":OUTP:OVP:CLEAR CH%d", channel),
+	)
+}
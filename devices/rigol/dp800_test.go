@@ -0,0 +1,189 @@
+package rigol
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDP800 is a DP800 series instrument listening on a local port.
+// All received commands are sent to cmds; queries are answered from
+// replies by prefix.
+type fakeDP800 struct {
+	addr    string
+	cmds    chan string
+	replies map[string]string
+}
+
+func newFakeDP800(t *testing.T, replies map[string]string) *fakeDP800 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeDP800{
+		addr:    ln.Addr().String(),
+		cmds:    make(chan string, 100),
+		replies: replies,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeDP800) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		f.cmds <- cmd
+		for prefix, resp := range f.replies {
+			if strings.HasPrefix(cmd, prefix) {
+				io.WriteString(conn, resp+"\n")
+				break
+			}
+		}
+	}
+}
+
+// expect waits for the next command.
+func (f *fakeDP800) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case cmd := <-f.cmds:
+		if cmd != want {
+			t.Errorf("got command %q, want %q", cmd, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("command %q not received", want)
+	}
+}
+
+func newProbedDP800(t *testing.T, replies map[string]string) (*DP800, *fakeDP800) {
+	replies["*IDN?"] = "RIGOL TECHNOLOGIES,DP832,DP8A000000001,00.01.14"
+	f := newFakeDP800(t, replies)
+	nt := NewDP800(f.addr, "")
+	if err := nt.Probe(); err != nil {
+		t.Fatal(err)
+	}
+	f.expect(t, "*IDN?")
+	return nt, f
+}
+
+func TestDP800Probe(t *testing.T) {
+	tests := []struct {
+		ident    string
+		channels int
+		err      bool
+	}{
+		{"RIGOL TECHNOLOGIES,DP832,DP8A000000001,00.01.14", 3, false},
+		{"RIGOL TECHNOLOGIES,DP832A,DP8B000000001,00.01.16", 3, false},
+		{"RIGOL TECHNOLOGIES,DP831A,DP8C000000001,00.01.16", 3, false},
+		{"RIGOL TECHNOLOGIES,DP821A,DP8D000000001,00.01.16", 2, false},
+		{"RIGOL TECHNOLOGIES,DP811,DP8E000000001,00.01.14", 1, false},
+		{"RIGOL TECHNOLOGIES,DL3021,DL3A000000001,00.01.02", 0, true},
+		{"garbage", 0, true},
+	}
+	for _, tc := range tests {
+		f := newFakeDP800(t, map[string]string{"*IDN?": tc.ident})
+		nt := NewDP800(f.addr, "")
+		err := nt.Probe()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.ident)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.ident, err)
+			continue
+		}
+		if n, _ := nt.GetChannels(); n != tc.channels {
+			t.Errorf("%s: got %d channels, want %d", tc.ident, n, tc.channels)
+		}
+		if ident, _ := nt.GetIdent(); ident != tc.ident {
+			t.Errorf("got ident %q, want %q", ident, tc.ident)
+		}
+	}
+}
+
+func TestDP800Measure(t *testing.T) {
+	nt, f := newProbedDP800(t, map[string]string{
+		":MEAS:ALL? CH2": "5.012,0.250,1.253",
+		":MEAS:ALL? CH3": "5.012,0.250",
+	})
+
+	voltage, current, power, err := nt.Measure(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.expect(t, ":MEAS:ALL? CH2")
+	if voltage != 5.012 || current != 0.25 || power != 1.253 {
+		t.Errorf("got %g V, %g A, %g W", voltage, current, power)
+	}
+	if current, err := nt.GetCurrent(2); err != nil || current != 0.25 {
+		t.Errorf("GetCurrent: got %g, %v", current, err)
+	}
+	f.expect(t, ":MEAS:ALL? CH2")
+
+	if _, _, _, err := nt.Measure(3); err == nil {
+		t.Error("expected an error for a short response")
+	}
+	f.expect(t, ":MEAS:ALL? CH3")
+}
+
+func TestDP800Commands(t *testing.T) {
+	nt, f := newProbedDP800(t, map[string]string{
+		":OUTP:OCP? CH1": "ON",
+		":OUTP:OVP? CH3": "OFF",
+	})
+
+	tests := []struct {
+		call func() error
+		cmd  string
+	}{
+		{func() error { return nt.SetOut(1, true) }, ":OUTP CH1,ON"},
+		{func() error { return nt.SetOut(3, false) }, ":OUTP CH3,OFF"},
+		{func() error { return nt.SetOCP(2, true) }, ":OUTP:OCP CH2,ON"},
+		{func() error { return nt.SetOVP(1, false) }, ":OUTP:OVP CH1,OFF"},
+		{func() error { return nt.SetOCPLevel(1, 1.5) }, ":OUTP:OCP:VAL CH1,1.500"},
+		{func() error { return nt.SetOVPLevel(2, 31) }, ":OUTP:OVP:VAL CH2,31.000"},
+		{func() error { return nt.SetVoltage(1, 12) }, ":APPL CH1,12.000"},
+		{func() error { return nt.SetCurrent(2, 0.5) }, ":SOUR2:CURR 0.500"},
+	}
+	for _, tc := range tests {
+		if err := tc.call(); err != nil {
+			t.Errorf("%s: %s", tc.cmd, err)
+			continue
+		}
+		f.expect(t, tc.cmd)
+	}
+
+	if ocp, err := nt.GetOCP(1); err != nil || !ocp {
+		t.Errorf("GetOCP: got %t, %v", ocp, err)
+	}
+	f.expect(t, ":OUTP:OCP? CH1")
+	if ovp, err := nt.GetOVP(3); err != nil || ovp {
+		t.Errorf("GetOVP: got %t, %v", ovp, err)
+	}
+	f.expect(t, ":OUTP:OVP? CH3")
+
+	if err := nt.SetOut(4, true); err == nil {
+		t.Error("SetOut accepted channel 4")
+	}
+}
@@ -5,26 +5,112 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/rumpelsepp/opennetzteil"
 )
 
+// Presets is the number of memories of the KA3005 family.
+const Presets = 5
+
+// The KA3005 protocol is used by several rebrands. The model is
+// detected via the ident; the patterns are matched against the ident
+// in upper case without spaces and dashes, e.g. "TENMA722540V2.1".
+var models = []struct {
+	pattern string
+	rating  opennetzteil.Rating
+}{
+	{"KA3005", opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150}},
+	{"KD3005", opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150}},
+	{"PS3005", opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150}},
+	{"KA6003", opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 3, MaxPower: 180}},
+	{"KD6003", opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 3, MaxPower: 180}},
+	{"722535", opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 3, MaxPower: 90}},
+	{"722540", opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150}},
+	{"722545", opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 2, MaxPower: 120}},
+	{"722550", opennetzteil.Rating{MaxVoltage: 60, MaxCurrent: 3, MaxPower: 180}},
+}
+
+// defaultRating is used if the ident is unknown; it
+// is the rating of the RND320-KA3005P.
+var defaultRating = opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150}
+
+func lookupRating(ident string) opennetzteil.Rating {
+	ident = strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(ident))
+	for _, m := range models {
+		if strings.Contains(ident, m.pattern) {
+			return m.rating
+		}
+	}
+	return defaultRating
+}
+
+// RND320 implements the RND320 and the other devices of the KA3005
+// family, such as the Korad KA3005P, the Tenma 72-2540, and the
+// Velleman PS3005D.
 type RND320 struct {
 	opennetzteil.NetzteilBase
-	path string
-	file *os.File
+	path   string
+	file   *os.File
+	rating opennetzteil.Rating
+	// The OVP state last set; see Status.
+	mutex sync.Mutex
+	ovp   *bool
 }
 
+// Tracking modes of the dual channel models.
+const (
+	TrackingIndependent = "independent"
+	TrackingSeries      = "series"
+	TrackingParallel    = "parallel"
+)
+
+// Bits of the response to STATUS?. The manuals document bit 5 as
+// the panel lock and leave bit 7 unused; the devices report the
+// OCP and the OVP there instead. The lock cannot be queried.
 const (
-	ChannelModeCC = 1 << iota
-	ChannelModeVC
+	statusCV       = 1 << 0 // bit 1 is the mode of the second channel
+	statusTracking = 3 << 2
+	statusBeep     = 1 << 4
+	statusOCP      = 1 << 5
+	statusOutput   = 1 << 6
+	statusOVP      = 1 << 7
 )
 
+var statusTrackingModes = map[byte]string{
+	0 << 2: TrackingIndependent,
+	1 << 2: TrackingSeries,
+	3 << 2: TrackingParallel,
+}
+
 type Status struct {
 	ChannelMode string
 	Output      bool
+	OCP         bool
+	// OVP is only valid while the output is on;
+	// some devices always report false otherwise.
+	OVP  bool
+	Beep bool
+	// Tracking is empty if the bits are invalid.
+	Tracking string `json:",omitempty"`
+}
+
+func decodeStatus(bits byte) Status {
+	status := Status{
+		ChannelMode: opennetzteil.ModeCC,
+		Output:      bits&statusOutput != 0,
+		OCP:         bits&statusOCP != 0,
+		OVP:         bits&statusOVP != 0,
+		Beep:        bits&statusBeep != 0,
+		Tracking:    statusTrackingModes[bits&statusTracking],
+	}
+	if bits&statusCV != 0 {
+		status.ChannelMode = opennetzteil.ModeCV
+	}
+	return status
 }
 
 func NewRND320(path, name string) (*RND320, error) {
@@ -36,9 +122,14 @@ func NewRND320(path, name string) (*RND320, error) {
 		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
 		file:         file,
 		path:         path,
+		rating:       defaultRating,
 	}, nil
 }
 
+func (nt *RND320) Close() error {
+	return nt.file.Close()
+}
+
 func (nt *RND320) reopenHandeIfNeeded(err error) error {
 	// This happens when the power supply itself is
 	// powercycled. In this case the handle must be renewed.
@@ -71,6 +162,9 @@ func (nt *RND320) request(cmd string, timeout time.Duration) ([]byte, error) {
 		}
 		break
 	}
+	if err == nil && len(resp) == 0 {
+		return nil, fmt.Errorf("%w: no response to '%s'", opennetzteil.ErrTimeout, cmd)
+	}
 	return resp, err
 }
 
@@ -91,55 +185,56 @@ func (nt *RND320) command(cmd string) error {
 	return err
 }
 
+func (nt *RND320) Ping() error {
+	_, err := nt.request("*IDN?", 1000*time.Millisecond)
+	return err
+}
+
 func (nt *RND320) Probe() error {
 	cmd := "*IDN?"
 	resp, err := nt.request(cmd, 1000*time.Millisecond)
 	if err != nil {
 		return err
 	}
-	nt.NetzteilBase.Ident = string(resp)
+	ident := strings.TrimSpace(string(resp))
+	nt.rating = lookupRating(ident)
+	nt.SetIdent(ident)
 	return nil
 }
 
-func (nt *RND320) Status() (interface{}, error) {
+func (nt *RND320) readStatus() (Status, error) {
 	cmd := "STATUS?"
 	resp, err := nt.request(cmd, 100*time.Millisecond)
 	if err != nil {
-		return nil, err
+		return Status{}, err
 	}
 	if len(resp) != 1 {
-		fmt.Println(resp)
-		return nil, fmt.Errorf("invalid data from device received")
+		return Status{}, fmt.Errorf("invalid data from device received")
 	}
+	return decodeStatus(resp[0]), nil
+}
 
-	var (
-		mode   string
-		output bool
-	)
-	if resp[0]&0x01 == 1 {
-		mode = "CV"
-	} else {
-		mode = "CC"
-	}
-	if resp[0]&0x40 == 1 {
-		output = true
-	} else {
-		output = false
+func (nt *RND320) Status() (interface{}, error) {
+	return nt.readStatus()
+}
+
+func (nt *RND320) GetMode(channel int) (string, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return "", err
 	}
-	status := Status{
-		ChannelMode: mode,
-		Output:      output,
+	status, err := nt.readStatus()
+	if err != nil {
+		return "", err
 	}
-	return status, nil
+	return status.ChannelMode, nil
 }
 
 func (nt *RND320) GetMaster() (bool, error) {
-	status, err := nt.Status()
+	status, err := nt.readStatus()
 	if err != nil {
 		return false, err
 	}
-	s := status.(Status)
-	return s.Output, nil
+	return status.Output, nil
 }
 
 func (nt *RND320) SetMaster(enabled bool) error {
@@ -157,7 +252,57 @@ func (nt *RND320) SetMaster(enabled bool) error {
 }
 
 func (nt *RND320) SetBeep(enabled bool) error {
-	return opennetzteil.ErrNotImplemented
+	if enabled {
+		return nt.command("BEEP1")
+	}
+	return nt.command("BEEP0")
+}
+
+// SetPanelLock locks or unlocks the front panel.
+func (nt *RND320) SetPanelLock(locked bool) error {
+	if locked {
+		return nt.command("LOCK1")
+	}
+	return nt.command("LOCK0")
+}
+
+func (nt *RND320) Capabilities() []string {
+	return []string{
+		opennetzteil.CapabilityStatus,
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityBeep,
+		opennetzteil.CapabilityOCP,
+		opennetzteil.CapabilityOVP,
+		opennetzteil.CapabilityPreset,
+		opennetzteil.CapabilityPanelLock,
+	}
+}
+
+func (nt *RND320) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.rating, nil
+}
+
+func (nt *RND320) GetPresets() (int, error) {
+	return Presets, nil
+}
+
+// SavePreset saves the setpoints to the memory preset.
+func (nt *RND320) SavePreset(preset int) error {
+	if err := opennetzteil.CheckPreset(preset, Presets); err != nil {
+		return err
+	}
+	return nt.command(fmt.Sprintf("SAV%d", preset))
+}
+
+// RecallPreset applies the setpoints of the memory preset.
+func (nt *RND320) RecallPreset(preset int) error {
+	if err := opennetzteil.CheckPreset(preset, Presets); err != nil {
+		return err
+	}
+	return nt.command(fmt.Sprintf("RCL%d", preset))
 }
 
 func (nt *RND320) GetChannels() (int, error) {
@@ -165,6 +310,9 @@ func (nt *RND320) GetChannels() (int, error) {
 }
 
 func (nt *RND320) GetCurrent(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
 	cmd := fmt.Sprintf("IOUT%d?", channel)
 	resp, err := nt.request(cmd, 100*time.Millisecond)
 	if err != nil {
@@ -179,6 +327,12 @@ func (nt *RND320) GetCurrent(channel int) (float64, error) {
 }
 
 func (nt *RND320) SetCurrent(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if max := nt.rating.MaxCurrent; current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
 	cmd := fmt.Sprintf("ISET%d:%.2f", channel, current)
 	err := nt.command(cmd)
 	if err != nil {
@@ -188,6 +342,9 @@ func (nt *RND320) SetCurrent(channel int, current float64) error {
 }
 
 func (nt *RND320) GetVoltage(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
 	cmd := fmt.Sprintf("VOUT%d?", channel)
 	resp, err := nt.request(cmd, 100*time.Millisecond)
 	if err != nil {
@@ -202,6 +359,12 @@ func (nt *RND320) GetVoltage(channel int) (float64, error) {
 }
 
 func (nt *RND320) SetVoltage(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if max := nt.rating.MaxVoltage; voltage < 0 || voltage > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
 	cmd := fmt.Sprintf("VSET%d:%.2f", channel, voltage)
 	err := nt.command(cmd)
 	if err != nil {
@@ -210,29 +373,102 @@ func (nt *RND320) SetVoltage(channel int, voltage float64) error {
 	return nil
 }
 
+// requestSetpoint queries a setpoint, e.g. VSET1?. Some firmware
+// versions append garbage to the response, which is dropped.
+func (nt *RND320) requestSetpoint(cmd string) (float64, error) {
+	resp, err := nt.request(cmd, 100*time.Millisecond)
+	if err != nil {
+		return 0, err
+	}
+	val := strings.TrimRightFunc(string(resp), func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	return strconv.ParseFloat(val, 32)
+}
+
+func (nt *RND320) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	return nt.requestSetpoint(fmt.Sprintf("VSET%d?", channel))
+}
+
+func (nt *RND320) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return 0, err
+	}
+	return nt.requestSetpoint(fmt.Sprintf("ISET%d?", channel))
+}
+
 func (nt *RND320) GetOut(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
 	return nt.GetMaster()
 }
 
 func (nt *RND320) SetOut(channel int, enabled bool) error {
-	if channel > 1 {
-		return fmt.Errorf("channel not avail")
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
 	}
 	return nt.SetMaster(enabled)
 }
 
 func (nt *RND320) GetOCP(channel int) (bool, error) {
-	return false, opennetzteil.ErrNotImplemented
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
+	status, err := nt.readStatus()
+	if err != nil {
+		return false, err
+	}
+	return status.OCP, nil
 }
 
 func (nt *RND320) SetOCP(channel int, enabled bool) error {
-	return opennetzteil.ErrNotImplemented
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	if enabled {
+		return nt.command("OCP1")
+	}
+	return nt.command("OCP0")
 }
 
+// GetOVP returns the state of the OVP. While the output is off, the
+// state last set is returned; ErrNotImplemented if it was not set.
 func (nt *RND320) GetOVP(channel int) (bool, error) {
-	return false, opennetzteil.ErrNotImplemented
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return false, err
+	}
+	status, err := nt.readStatus()
+	if err != nil {
+		return false, err
+	}
+	if status.Output {
+		return status.OVP, nil
+	}
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+	if nt.ovp == nil {
+		return false, opennetzteil.ErrNotImplemented
+	}
+	return *nt.ovp, nil
 }
 
 func (nt *RND320) SetOVP(channel int, enabled bool) error {
-	return opennetzteil.ErrNotImplemented
+	if err := opennetzteil.CheckChannel(channel, 1); err != nil {
+		return err
+	}
+	cmd := "OVP0"
+	if enabled {
+		cmd = "OVP1"
+	}
+	if err := nt.command(cmd); err != nil {
+		return err
+	}
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+	nt.ovp = &enabled
+	return nil
 }
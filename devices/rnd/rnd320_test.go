@@ -0,0 +1,84 @@
+package rnd
+
+import (
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// The status bytes below are derived from the bit layout documented
+// in rnd320.go; they are not captured from a device.
+func TestDecodeStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		bits   byte
+		status Status
+	}{
+		{
+			name:   "cc, output off",
+			bits:   0x00,
+			status: Status{ChannelMode: opennetzteil.ModeCC, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "cv, output off, beep",
+			bits:   0x11,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "cc, output on, beep",
+			bits:   0x50,
+			status: Status{ChannelMode: opennetzteil.ModeCC, Output: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			// Bit 1 is the mode of the second channel.
+			name:   "channel 1 cc, channel 2 cv",
+			bits:   0x52,
+			status: Status{ChannelMode: opennetzteil.ModeCC, Output: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "channel 1 cv, channel 2 cc",
+			bits:   0x51,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Output: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "channel 1 cv, channel 2 cv",
+			bits:   0x53,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Output: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "ocp",
+			bits:   0x31,
+			status: Status{ChannelMode: opennetzteil.ModeCV, OCP: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "ovp, output on",
+			bits:   0xd1,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Output: true, OVP: true, Beep: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "ocp and ovp, output on, no beep",
+			bits:   0xe1,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Output: true, OCP: true, OVP: true, Tracking: TrackingIndependent},
+		},
+		{
+			name:   "tracking series",
+			bits:   0x15,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Beep: true, Tracking: TrackingSeries},
+		},
+		{
+			name:   "tracking invalid",
+			bits:   0x19,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Beep: true},
+		},
+		{
+			name:   "tracking parallel",
+			bits:   0x1d,
+			status: Status{ChannelMode: opennetzteil.ModeCV, Beep: true, Tracking: TrackingParallel},
+		},
+	}
+	for _, tc := range tests {
+		if got := decodeStatus(tc.bits); got != tc.status {
+			t.Errorf("%s: decodeStatus(%#02x) = %+v, want %+v", tc.name, tc.bits, got, tc.status)
+		}
+	}
+}
@@ -2,169 +2,491 @@ package rs
 
 import (
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rumpelsepp/opennetzteil"
 )
 
+type model struct {
+	// ratings of the channels, starting with channel 1.
+	ratings []opennetzteil.Rating
+	// hmp is set for the HMP series, which switches the outputs
+	// via OUTP:GEN and OUTP:SEL instead of OUTP:MAST and OUTP:CHAN.
+	hmp bool
+}
+
+var models = map[string]model{
+	"HMC8041": {[]opennetzteil.Rating{{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 100}}, false},
+	"HMC8042": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 100},
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 100},
+	}, false},
+	"HMC8043": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 3, MaxPower: 33},
+		{MaxVoltage: 32, MaxCurrent: 3, MaxPower: 33},
+		{MaxVoltage: 32, MaxCurrent: 3, MaxPower: 33},
+	}, false},
+	"HMP2020": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 80},
+	}, true},
+	"HMP2030": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 80},
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 80},
+		{MaxVoltage: 32, MaxCurrent: 5, MaxPower: 80},
+	}, true},
+	"HMP4030": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+	}, true},
+	"HMP4040": {[]opennetzteil.Rating{
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+		{MaxVoltage: 32, MaxCurrent: 10, MaxPower: 160},
+	}, true},
+}
+
+// defaultModel is used until the device was probed.
+const defaultModel = "HMC8043"
+
+// Bits of the questionable instrument summary register
+// of a channel, STAT:QUES:INST:ISUM<n>.
+const (
+	quesCC          = 1 << 0
+	quesCV          = 1 << 1
+	quesTemperature = 1 << 4
+	quesOVP         = 1 << 9
+	quesFuse        = 1 << 10
+)
+
+// HMC804 implements the R&S HMC8041, HMC8042, HMC8043, and the
+// HMP2020, HMP2030, HMP4030, and HMP4040. Most commands apply to the
+// channel selected via INST; the selection and the command are sent
+// in one connection.
 type HMC804 struct {
 	opennetzteil.NetzteilBase
 	target string
+	model  model
+	// mutex serializes commands which select a channel.
+	mutex sync.Mutex
 }
 
-type Status struct {
+type ChannelStatus struct {
 	ChannelMode string
 	Output      bool
+	// FuseTripped reports whether the electronic fuse, i.e. the OCP, tripped.
+	FuseTripped     bool
+	OVPTripped      bool
+	Overtemperature bool
+}
+
+type Status struct {
+	Master   bool
+	Channels []ChannelStatus
 }
 
 func NewHMC804(target, name string) *HMC804 {
 	return &HMC804{
-		target: target,
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		target:       target,
+		model:        models[defaultModel],
 	}
 }
 
-func (nt *HMC804) getIdent() (string, error) {
-	cmd := "*IDN?"
-	resp, err := nt.TCPRequest(nt.target, cmd)
+func parseBool(resp string) (bool, error) {
+	switch strings.ToUpper(resp) {
+	case "ON":
+		return true, nil
+	case "OFF":
+		return false, nil
+	}
+	return strconv.ParseBool(resp)
+}
+
+// request sends cmd and returns the response. If channel is not 0,
+// the channel is selected before.
+func (nt *HMC804) request(channel int, cmd string) (string, error) {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	conn, err := net.Dial("tcp", nt.target)
+	if err != nil {
+		return "", opennetzteil.TransportError(err)
+	}
+	defer conn.Close()
+	if channel != 0 {
+		if err := nt.SendCommandLine(conn, []byte(fmt.Sprintf("INST OUT%d", channel))); err != nil {
+			return "", err
+		}
+	}
+	resp, err := nt.RequestLine(conn, []byte(cmd))
 	if err != nil {
 		return "", err
 	}
-	return string(resp), nil
+	return strings.TrimSpace(string(resp)), nil
+}
+
+func (nt *HMC804) requestFloat(channel int, cmd string) (float64, error) {
+	resp, err := nt.request(channel, cmd)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp, 64)
+}
+
+func (nt *HMC804) requestBool(channel int, cmd string) (bool, error) {
+	resp, err := nt.request(channel, cmd)
+	if err != nil {
+		return false, err
+	}
+	return parseBool(resp)
+}
+
+// send sends cmds in one connection. If channel is not 0,
+// the channel is selected before.
+func (nt *HMC804) send(channel int, cmds ...string) error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if channel != 0 {
+		cmds = append([]string{fmt.Sprintf("INST OUT%d", channel)}, cmds...)
+	}
+	return nt.TCPSendBatched(nt.target, cmds)
+}
+
+func (nt *HMC804) checkChannel(channel int) error {
+	return opennetzteil.CheckChannel(channel, len(nt.model.ratings))
+}
+
+func (nt *HMC804) Ping() error {
+	_, err := nt.request(0, "*IDN?")
+	return err
 }
 
 func (nt *HMC804) Probe() error {
-	ident, err := nt.getIdent()
+	ident, err := nt.request(0, "*IDN?")
 	if err != nil {
 		return err
 	}
-	nt.NetzteilBase.Ident = ident
+	idn := opennetzteil.ParseIdent(ident)
+	m, ok := models[strings.ToUpper(idn.Model)]
+	if !ok {
+		return fmt.Errorf("unsupported model: %s", idn.Model)
+	}
+	nt.model = m
+	nt.SetIdent(ident)
 	return nil
 }
 
 func (nt *HMC804) Status() (interface{}, error) {
-	return false, opennetzteil.ErrNotImplemented
+	var (
+		status Status
+		err    error
+	)
+	if status.Master, err = nt.GetMaster(); err != nil {
+		return nil, err
+	}
+	for ch := 1; ch <= len(nt.model.ratings); ch++ {
+		ques, err := nt.questionable(ch)
+		if err != nil {
+			return nil, err
+		}
+		cs := ChannelStatus{
+			ChannelMode:     opennetzteil.ModeCV,
+			FuseTripped:     ques&quesFuse != 0,
+			OVPTripped:      ques&quesOVP != 0,
+			Overtemperature: ques&quesTemperature != 0,
+		}
+		if ques&quesCC != 0 {
+			cs.ChannelMode = opennetzteil.ModeCC
+		}
+		if cs.Output, err = nt.GetOut(ch); err != nil {
+			return nil, err
+		}
+		status.Channels = append(status.Channels, cs)
+	}
+	return status, nil
 }
 
-func (nt *HMC804) GetMaster() (bool, error) {
-	resp, err := nt.TCPRequest(nt.target, "OUTP:MAST:STAT?")
+// questionable returns the condition of the questionable
+// instrument summary register of channel.
+func (nt *HMC804) questionable(channel int) (uint64, error) {
+	resp, err := nt.request(0, fmt.Sprintf("STAT:QUES:INST:ISUM%d:COND?", channel))
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+	return strconv.ParseUint(resp, 10, 16)
+}
+
+func (nt *HMC804) GetMaster() (bool, error) {
+	if nt.model.hmp {
+		return nt.requestBool(0, "OUTP:GEN?")
 	}
-	return strconv.ParseBool(string(resp))
+	return nt.requestBool(0, "OUTP:MAST:STAT?")
 }
 
 func (nt *HMC804) SetMaster(enabled bool) error {
-	var cmd string
-	if enabled {
-		cmd = "OUTP:MAST ON"
-	} else {
-		cmd = "OUTP:MAST OFF"
+	cmd := "OUTP:MAST"
+	if nt.model.hmp {
+		cmd = "OUTP:GEN"
 	}
-	if err := nt.TCPSend(nt.target, cmd); err != nil {
-		return err
+	if enabled {
+		return nt.send(0, cmd+" ON")
 	}
-	return nil
+	return nt.send(0, cmd+" OFF")
 }
 
 func (nt *HMC804) SetBeep(enabled bool) error {
 	return opennetzteil.ErrNotImplemented
 }
 
+func (nt *HMC804) Capabilities() []string {
+	return []string{
+		opennetzteil.CapabilityStatus,
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityOCP,
+		opennetzteil.CapabilityOVP,
+		opennetzteil.CapabilityFuse,
+	}
+}
+
+func (nt *HMC804) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.model.ratings[channel-1], nil
+}
+
+func (nt *HMC804) GetMode(channel int) (string, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return "", err
+	}
+	ques, err := nt.questionable(channel)
+	if err != nil {
+		return "", err
+	}
+	if ques&quesCC != 0 {
+		return opennetzteil.ModeCC, nil
+	}
+	return opennetzteil.ModeCV, nil
+}
+
+// GetProtectionTripped reports whether the fuse or the OVP tripped.
+func (nt *HMC804) GetProtectionTripped(channel int) (bool, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	ques, err := nt.questionable(channel)
+	if err != nil {
+		return false, err
+	}
+	return ques&(quesFuse|quesOVP) != 0, nil
+}
+
+// ClearProtection clears a tripped OVP of channel. A tripped
+// fuse is cleared by switching the output on again.
+func (nt *HMC804) ClearProtection(channel int) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(channel, "VOLT:PROT:CLE")
+}
+
 func (nt *HMC804) GetChannels() (int, error) {
-	return 3, nil
+	return len(nt.model.ratings), nil
 }
 
 func (nt *HMC804) GetCurrent(channel int) (float64, error) {
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	if err := nt.TCPSend(nt.target, cmd); err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return 0, err
 	}
-	resp, err := nt.TCPRequest(nt.target, "CURR?")
-	if err != nil {
-		return 0, err
+	return nt.requestFloat(channel, "MEAS:CURR?")
+}
+
+func (nt *HMC804) checkCurrent(channel int, current float64) error {
+	if max := nt.model.ratings[channel-1].MaxCurrent; current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nil
+}
+
+func (nt *HMC804) checkVoltage(channel int, voltage float64) error {
+	if max := nt.model.ratings[channel-1].MaxVoltage; voltage < 0 || voltage > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
 	}
-	return strconv.ParseFloat(string(resp), 32)
+	return nil
 }
 
 func (nt *HMC804) SetCurrent(channel int, current float64) error {
-	var cmds []string
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	cmds = append(cmds, cmd)
-	cmd = fmt.Sprintf("CURR %.3f", current)
-	cmds = append(cmds, cmd)
-	if err := nt.TCPSendBatched(nt.target, cmds); err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return err
 	}
-	return nil
+	if err := nt.checkCurrent(channel, current); err != nil {
+		return err
+	}
+	return nt.send(channel, fmt.Sprintf("CURR %.3f", current))
 }
 
 func (nt *HMC804) GetVoltage(channel int) (float64, error) {
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	if err := nt.TCPSend(nt.target, cmd); err != nil {
-		return 0, err
-	}
-	resp, err := nt.TCPRequest(nt.target, "VOLT?")
-	if err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return 0, err
 	}
-	return strconv.ParseFloat(string(resp), 32)
+	return nt.requestFloat(channel, "MEAS:VOLT?")
 }
 
 func (nt *HMC804) SetVoltage(channel int, voltage float64) error {
-	var cmds []string
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	cmds = append(cmds, cmd)
-	cmd = fmt.Sprintf("VOLT %.3f", voltage)
-	cmds = append(cmds, cmd)
-	if err := nt.TCPSendBatched(nt.target, cmds); err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return err
 	}
-	return nil
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	return nt.send(channel, fmt.Sprintf("VOLT %.3f", voltage))
+}
+
+func (nt *HMC804) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(channel, "VOLT?")
+}
+
+func (nt *HMC804) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(channel, "CURR?")
 }
 
 func (nt *HMC804) GetOut(channel int) (bool, error) {
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	if err := nt.TCPSend(nt.target, cmd); err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return false, err
 	}
-	resp, err := nt.TCPRequest(nt.target, "OUTP:STAT?")
-	if err != nil {
-		return false, err
+	if nt.model.hmp {
+		return nt.requestBool(channel, "OUTP:SEL?")
 	}
-	return strconv.ParseBool(string(resp))
+	return nt.requestBool(channel, "OUTP:STAT?")
 }
 
 func (nt *HMC804) SetOut(channel int, enabled bool) error {
-	var cmds []string
-	cmd := fmt.Sprintf("INST OUT%d", channel)
-	if err := nt.TCPSend(nt.target, cmd); err != nil {
+	if err := nt.checkChannel(channel); err != nil {
 		return err
 	}
-	cmds = append(cmds, cmd)
-	if enabled {
-		cmd = "OUTP:CHAN ON"
-	} else {
-		cmd = "OUTP:CHAN OFF"
+	cmd := "OUTP:CHAN"
+	if nt.model.hmp {
+		cmd = "OUTP:SEL"
 	}
-	cmds = append(cmds, cmd)
-	if err := nt.TCPSendBatched(nt.target, cmds); err != nil {
-		return err
+	if enabled {
+		return nt.send(channel, cmd+" ON")
 	}
-	return nil
+	return nt.send(channel, cmd+" OFF")
 }
 
+// The OCP is implemented by the electronic fuse of the channels.
+
 func (nt *HMC804) GetOCP(channel int) (bool, error) {
-	return false, opennetzteil.ErrNotImplemented
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	return nt.requestBool(channel, "FUSE:STAT?")
 }
 
 func (nt *HMC804) SetOCP(channel int, enabled bool) error {
-	return opennetzteil.ErrNotImplemented
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if enabled {
+		return nt.send(channel, "FUSE:STAT ON")
+	}
+	return nt.send(channel, "FUSE:STAT OFF")
+}
+
+// GetFuseDelay returns the time the fuse of channel waits
+// before it trips.
+func (nt *HMC804) GetFuseDelay(channel int) (time.Duration, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	ms, err := nt.requestFloat(channel, "FUSE:DEL?")
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// SetFuseDelay sets the time the fuse of channel waits before it
+// trips; the devices accept 0 to 250 ms in steps of 1 ms.
+func (nt *HMC804) SetFuseDelay(channel int, delay time.Duration) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if delay < 0 || delay > 250*time.Millisecond {
+		return fmt.Errorf("%w: fuse delay %s; must be 0-250 ms", opennetzteil.ErrOutOfRange, delay)
+	}
+	return nt.send(channel, fmt.Sprintf("FUSE:DEL %d", delay.Milliseconds()))
+}
+
+// LinkFuse links the fuse of channel to the fuse of other: if
+// the fuse of other trips, the output of channel is switched off.
+func (nt *HMC804) LinkFuse(channel, other int) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if err := nt.checkChannel(other); err != nil {
+		return err
+	}
+	return nt.send(channel, fmt.Sprintf("FUSE:LINK %d", other))
+}
+
+// UnlinkFuse removes the link of the fuse of channel to the fuse of other.
+func (nt *HMC804) UnlinkFuse(channel, other int) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if err := nt.checkChannel(other); err != nil {
+		return err
+	}
+	return nt.send(channel, fmt.Sprintf("FUSE:UNL %d", other))
 }
 
 func (nt *HMC804) GetOVP(channel int) (bool, error) {
-	return false, opennetzteil.ErrNotImplemented
+	if err := nt.checkChannel(channel); err != nil {
+		return false, err
+	}
+	return nt.requestBool(channel, "VOLT:PROT:STAT?")
 }
 
 func (nt *HMC804) SetOVP(channel int, enabled bool) error {
-	return opennetzteil.ErrNotImplemented
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if enabled {
+		return nt.send(channel, "VOLT:PROT:STAT ON")
+	}
+	return nt.send(channel, "VOLT:PROT:STAT OFF")
+}
+
+// GetOVPLevel returns the voltage at which the OVP trips.
+func (nt *HMC804) GetOVPLevel(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(channel, "VOLT:PROT:LEV?")
+}
+
+// SetOVPLevel sets the voltage at which the OVP trips.
+func (nt *HMC804) SetOVPLevel(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if err := nt.checkVoltage(channel, voltage); err != nil {
+		return err
+	}
+	return nt.send(channel, fmt.Sprintf("VOLT:PROT:LEV %.3f", voltage))
 }
@@ -0,0 +1,161 @@
+package rs
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeHMC804 is an HMC or HMP series instrument listening on a local
+// port. All received commands are sent to cmds; queries other than
+// *IDN? are answered with 1.
+type fakeHMC804 struct {
+	addr  string
+	ident string
+	cmds  chan string
+}
+
+func newFakeHMC804(t *testing.T, ident string) *fakeHMC804 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeHMC804{
+		addr:  ln.Addr().String(),
+		ident: ident,
+		cmds:  make(chan string, 100),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeHMC804) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		f.cmds <- cmd
+		switch {
+		case cmd == "*IDN?":
+			io.WriteString(conn, f.ident+"\n")
+		case strings.HasSuffix(cmd, "?"):
+			io.WriteString(conn, "1\n")
+		}
+	}
+}
+
+// expect waits for the next commands.
+func (f *fakeHMC804) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case cmd := <-f.cmds:
+			if cmd != w {
+				t.Errorf("got command %q, want %q", cmd, w)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("command %q not received", w)
+		}
+	}
+}
+
+func TestHMC804Family(t *testing.T) {
+	tests := []struct {
+		ident     string
+		master    string
+		getMaster string
+		out       string
+		getOut    string
+	}{
+		{"Rohde&Schwarz,HMC8043,000000001,01.400", "OUTP:MAST", "OUTP:MAST:STAT?", "OUTP:CHAN", "OUTP:STAT?"},
+		{"ROHDE&SCHWARZ,HMP4040,000000001,HW50020001/SW2.51", "OUTP:GEN", "OUTP:GEN?", "OUTP:SEL", "OUTP:SEL?"},
+	}
+	for _, tc := range tests {
+		f := newFakeHMC804(t, tc.ident)
+		nt := NewHMC804(f.addr, "")
+		if err := nt.Probe(); err != nil {
+			t.Fatal(err)
+		}
+		f.expect(t, "*IDN?")
+
+		if err := nt.SetMaster(true); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, tc.master+" ON")
+		if master, err := nt.GetMaster(); err != nil || !master {
+			t.Errorf("GetMaster: got %t, %v", master, err)
+		}
+		f.expect(t, tc.getMaster)
+
+		if err := nt.SetOut(2, false); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, "INST OUT2", tc.out+" OFF")
+		if out, err := nt.GetOut(3); err != nil || !out {
+			t.Errorf("GetOut: got %t, %v", out, err)
+		}
+		f.expect(t, "INST OUT3", tc.getOut)
+
+		if _, err := nt.GetVoltage(1); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, "INST OUT1", "MEAS:VOLT?")
+		if _, err := nt.GetVoltageSetpoint(1); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, "INST OUT1", "VOLT?")
+		if _, err := nt.GetCurrent(2); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, "INST OUT2", "MEAS:CURR?")
+		if _, err := nt.GetCurrentSetpoint(2); err != nil {
+			t.Error(err)
+		}
+		f.expect(t, "INST OUT2", "CURR?")
+	}
+}
+
+func TestHMC804Fuse(t *testing.T) {
+	f := newFakeHMC804(t, "Rohde&Schwarz,HMC8043,000000001,01.400")
+	nt := NewHMC804(f.addr, "")
+
+	if err := nt.SetFuseDelay(1, 20*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	f.expect(t, "INST OUT1", "FUSE:DEL 20")
+	if err := nt.SetFuseDelay(1, time.Second); err == nil {
+		t.Error("SetFuseDelay accepted 1 s")
+	}
+	if delay, err := nt.GetFuseDelay(2); err != nil || delay != time.Millisecond {
+		t.Errorf("GetFuseDelay: got %s, %v", delay, err)
+	}
+	f.expect(t, "INST OUT2", "FUSE:DEL?")
+	if err := nt.LinkFuse(1, 3); err != nil {
+		t.Error(err)
+	}
+	f.expect(t, "INST OUT1", "FUSE:LINK 3")
+	if err := nt.UnlinkFuse(2, 1); err != nil {
+		t.Error(err)
+	}
+	f.expect(t, "INST OUT2", "FUSE:UNL 1")
+	if err := nt.LinkFuse(1, 4); err == nil {
+		t.Error("LinkFuse accepted channel 4")
+	}
+}
@@ -0,0 +1,613 @@
+package scpi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultPort is the common port for raw SCPI via TCP.
+const DefaultPort = "5025"
+
+const timeout = 2 * time.Second
+
+// Placeholders look like {ch} or {v:.3f}; the format
+// is a verb of the fmt package without the percent sign.
+var placeholderRe = regexp.MustCompile(`\{(\w+)(?::([^}]+))?\}`)
+
+// Commands is the template set of a device. Within templates, {ch}
+// is replaced by the channel and {v} by the value to be set; boolean
+// values are replaced by On or Off. Missing commands make the
+// corresponding function unavailable.
+type Commands struct {
+	Ident      string
+	Channels   int
+	MaxVoltage float64 `toml:"max_voltage"`
+	MaxCurrent float64 `toml:"max_current"`
+	// Terminator is appended to all commands.
+	// Defaults to "\n".
+	Terminator string
+	// ResponseTerminator terminates responses; only the first
+	// character is used. Defaults to "\n".
+	ResponseTerminator string `toml:"response_terminator"`
+	// On and Off are used for boolean values.
+	// Defaults to "ON" and "OFF".
+	On  string
+	Off string
+	// Delay is the time in milliseconds waited after each command.
+	Delay int
+
+	GetMaster  string `toml:"get_master"`
+	SetMaster  string `toml:"set_master"`
+	SetBeep    string `toml:"set_beep"`
+	GetVoltage string `toml:"get_voltage"`
+	SetVoltage string `toml:"set_voltage"`
+	GetCurrent string `toml:"get_current"`
+	SetCurrent string `toml:"set_current"`
+	GetOut     string `toml:"get_out"`
+	SetOut     string `toml:"set_out"`
+	GetOCP     string `toml:"get_ocp"`
+	SetOCP     string `toml:"set_ocp"`
+	GetOVP     string `toml:"get_ovp"`
+	SetOVP     string `toml:"set_ovp"`
+	// GetMode must respond with a string containing CC or CV.
+	GetMode string `toml:"get_mode"`
+	// GetVoltageSetpoint and GetCurrentSetpoint query the setpoints,
+	// whereas GetVoltage and GetCurrent query the measured values.
+	GetVoltageSetpoint string `toml:"get_voltage_setpoint"`
+	GetCurrentSetpoint string `toml:"get_current_setpoint"`
+
+	// Patterns maps command names, e.g. get_voltage, to regular
+	// expressions. The first submatch, or the match if there is no
+	// submatch, of the response is parsed.
+	Patterns map[string]string
+	// Delays maps command names to the time in milliseconds
+	// waited after the command; it overrides Delay.
+	Delays map[string]int
+
+	patterns map[string]*regexp.Regexp
+}
+
+func (c *Commands) templates() map[string]string {
+	return map[string]string{
+		"ident":       c.Ident,
+		"get_master":  c.GetMaster,
+		"set_master":  c.SetMaster,
+		"set_beep":    c.SetBeep,
+		"get_voltage": c.GetVoltage,
+		"set_voltage": c.SetVoltage,
+		"get_current": c.GetCurrent,
+		"set_current": c.SetCurrent,
+		"get_out":     c.GetOut,
+		"set_out":     c.SetOut,
+		"get_ocp":     c.GetOCP,
+		"set_ocp":     c.SetOCP,
+		"get_ovp":     c.GetOVP,
+		"set_ovp":     c.SetOVP,
+		"get_mode":    c.GetMode,
+
+		"get_voltage_setpoint": c.GetVoltageSetpoint,
+		"get_current_setpoint": c.GetCurrentSetpoint,
+	}
+}
+
+// Copy returns a deep copy of c.
+func (c *Commands) Copy() *Commands {
+	if c == nil {
+		return nil
+	}
+	n := *c
+	if c.Patterns != nil {
+		n.Patterns = make(map[string]string, len(c.Patterns))
+		for name, pattern := range c.Patterns {
+			n.Patterns[name] = pattern
+		}
+	}
+	if c.Delays != nil {
+		n.Delays = make(map[string]int, len(c.Delays))
+		for name, delay := range c.Delays {
+			n.Delays[name] = delay
+		}
+	}
+	n.patterns = nil
+	return &n
+}
+
+// Validate checks c and sets the defaults.
+func (c *Commands) Validate() error {
+	if c.Ident == "" {
+		c.Ident = "*IDN?"
+	}
+	if c.Channels == 0 {
+		c.Channels = 1
+	}
+	if c.Terminator == "" {
+		c.Terminator = "\n"
+	}
+	if c.ResponseTerminator == "" {
+		c.ResponseTerminator = "\n"
+	}
+	if c.On == "" {
+		c.On = "ON"
+	}
+	if c.Off == "" {
+		c.Off = "OFF"
+	}
+	templates := c.templates()
+	for name, tmpl := range templates {
+		if tmpl == "" {
+			continue
+		}
+		if _, err := render(tmpl, 1, 1.0); err != nil {
+			return fmt.Errorf("command %s: %w", name, err)
+		}
+	}
+	c.patterns = make(map[string]*regexp.Regexp)
+	for name, pattern := range c.Patterns {
+		if _, ok := templates[name]; !ok {
+			return fmt.Errorf("pattern for unknown command: %s", name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %s: %w", name, err)
+		}
+		c.patterns[name] = re
+	}
+	for name := range c.Delays {
+		if _, ok := templates[name]; !ok {
+			return fmt.Errorf("delay for unknown command: %s", name)
+		}
+	}
+	return nil
+}
+
+// render replaces the placeholders {ch} and {v} of tmpl.
+func render(tmpl string, channel int, value interface{}) (string, error) {
+	var err error
+	res := placeholderRe.ReplaceAllStringFunc(tmpl, func(match string) string {
+		var (
+			m   = placeholderRe.FindStringSubmatch(match)
+			val interface{}
+		)
+		switch m[1] {
+		case "ch":
+			val = channel
+		case "v":
+			val = value
+		default:
+			err = fmt.Errorf("unknown placeholder: %s", match)
+			return match
+		}
+		if m[2] == "" {
+			return fmt.Sprint(val)
+		}
+		s := fmt.Sprintf("%"+m[2], val)
+		if strings.Contains(s, "%!") {
+			err = fmt.Errorf("invalid format: %s", match)
+		}
+		return s
+	})
+	return res, err
+}
+
+// Generic implements SCPI-like devices whose commands
+// are described by a Commands template set.
+type Generic struct {
+	opennetzteil.NetzteilBase
+	dial  func() (io.ReadWriteCloser, error)
+	conn  io.ReadWriteCloser
+	cmds  *Commands
+	mutex sync.Mutex
+}
+
+// newGeneric validates a copy of cmds; the defaults
+// are not written back to the configuration.
+func newGeneric(cmds *Commands, name string) (*Generic, error) {
+	if cmds == nil {
+		return nil, fmt.Errorf("commands are missing")
+	}
+	cmds = cmds.Copy()
+	if err := cmds.Validate(); err != nil {
+		return nil, err
+	}
+	return &Generic{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		cmds:         cmds,
+	}, nil
+}
+
+// NewGenericTCP creates a driver for a device
+// reachable via TCP; target is host:port.
+func NewGenericTCP(target string, cmds *Commands, name string) (*Generic, error) {
+	nt, err := newGeneric(cmds, name)
+	if err != nil {
+		return nil, err
+	}
+	nt.dial = func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", target, timeout)
+	}
+	return nt, nil
+}
+
+// NewGenericFile creates a driver for a device connected via the
+// character device path, e.g. a serial port or a usbtmc device.
+func NewGenericFile(path string, cmds *Commands, name string) (*Generic, error) {
+	nt, err := newGeneric(cmds, name)
+	if err != nil {
+		return nil, err
+	}
+	nt.dial = func() (io.ReadWriteCloser, error) {
+		return os.OpenFile(path, os.O_RDWR, 0644)
+	}
+	return nt, nil
+}
+
+func (nt *Generic) Close() error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		return nil
+	}
+	err := nt.conn.Close()
+	nt.conn = nil
+	return err
+}
+
+// exchange runs f with the connection to the device. The connection
+// is established on demand and closed after transport errors.
+func (nt *Generic) exchange(f func(conn io.ReadWriter) error) error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	if nt.conn == nil {
+		conn, err := nt.dial()
+		if err != nil {
+			return opennetzteil.TransportError(err)
+		}
+		nt.conn = withTimeout(conn)
+	}
+	if c, ok := nt.conn.(deadliner); ok {
+		if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+			nt.conn.Close()
+			nt.conn = nil
+			return opennetzteil.TransportError(err)
+		}
+	}
+	err := f(nt.conn)
+	if errors.Is(err, opennetzteil.ErrTransport) || errors.Is(err, opennetzteil.ErrTimeout) {
+		nt.conn.Close()
+		nt.conn = nil
+	}
+	return err
+}
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// withTimeout returns conn if it supports deadlines. Otherwise,
+// e.g. for usbtmc devices, the reads of conn are limited by a timer.
+func withTimeout(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	if c, ok := conn.(deadliner); ok && c.SetDeadline(time.Time{}) == nil {
+		return conn
+	}
+	return &timeoutConn{ReadWriteCloser: conn, timeout: timeout}
+}
+
+// timeoutConn limits each read to timeout. A read which timed out
+// keeps running in the background until the device or the kernel
+// driver gives up; its data is discarded, since exchange closes the
+// connection after a timeout.
+type timeoutConn struct {
+	io.ReadWriteCloser
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	type result struct {
+		buf []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf := make([]byte, len(p))
+		n, err := c.ReadWriteCloser.Read(buf)
+		done <- result{buf[:n], err}
+	}()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return copy(p, res.buf), res.err
+	case <-timer.C:
+		return 0, fmt.Errorf("%w: no response within %s", opennetzteil.ErrTimeout, c.timeout)
+	}
+}
+
+func (nt *Generic) delay(name string) {
+	ms, ok := nt.cmds.Delays[name]
+	if !ok {
+		ms = nt.cmds.Delay
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+}
+
+func (nt *Generic) template(name string) (string, error) {
+	tmpl := nt.cmds.templates()[name]
+	if tmpl == "" {
+		return "", opennetzteil.ErrNotImplemented
+	}
+	return tmpl, nil
+}
+
+// send renders the command name and sends it.
+func (nt *Generic) send(name string, channel int, value interface{}) error {
+	tmpl, err := nt.template(name)
+	if err != nil {
+		return err
+	}
+	cmd, err := render(tmpl, channel, value)
+	if err != nil {
+		return err
+	}
+	return nt.exchange(func(conn io.ReadWriter) error {
+		var err error
+		if nt.cmds.Terminator == "\n" {
+			err = nt.SendCommandLine(conn, []byte(cmd))
+		} else {
+			err = nt.SendCommand(conn, []byte(cmd+nt.cmds.Terminator))
+		}
+		nt.delay(name)
+		return err
+	})
+}
+
+// request renders the command name, sends it, and returns
+// the response after applying the pattern of the command.
+func (nt *Generic) request(name string, channel int) (string, error) {
+	tmpl, err := nt.template(name)
+	if err != nil {
+		return "", err
+	}
+	cmd, err := render(tmpl, channel, nil)
+	if err != nil {
+		return "", err
+	}
+	var resp []byte
+	err = nt.exchange(func(conn io.ReadWriter) error {
+		var err error
+		switch {
+		case nt.cmds.Terminator == "\n" && nt.cmds.ResponseTerminator == "\n":
+			resp, err = nt.RequestLine(conn, []byte(cmd))
+		default:
+			resp, err = nt.RequestUntil(conn, []byte(cmd+nt.cmds.Terminator), nt.cmds.ResponseTerminator[0])
+		}
+		nt.delay(name)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	res := strings.TrimSpace(string(resp))
+	if re, ok := nt.cmds.patterns[name]; ok {
+		m := re.FindStringSubmatch(res)
+		switch {
+		case m == nil:
+			return "", fmt.Errorf("unexpected response to '%s': %s", cmd, res)
+		case len(m) > 1:
+			res = m[1]
+		default:
+			res = m[0]
+		}
+	}
+	return res, nil
+}
+
+func (nt *Generic) requestFloat(name string, channel int) (float64, error) {
+	resp, err := nt.request(name, channel)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp, 64)
+}
+
+func (nt *Generic) requestBool(name string, channel int) (bool, error) {
+	resp, err := nt.request(name, channel)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case strings.EqualFold(resp, nt.cmds.On), resp == "1":
+		return true, nil
+	case strings.EqualFold(resp, nt.cmds.Off), resp == "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected response to %s: %s", name, resp)
+}
+
+func (nt *Generic) sendBool(name string, channel int, enabled bool) error {
+	if enabled {
+		return nt.send(name, channel, nt.cmds.On)
+	}
+	return nt.send(name, channel, nt.cmds.Off)
+}
+
+func (nt *Generic) Ping() error {
+	_, err := nt.request("ident", 0)
+	return err
+}
+
+func (nt *Generic) Probe() error {
+	ident, err := nt.request("ident", 0)
+	if err != nil {
+		return err
+	}
+	nt.SetIdent(ident)
+	return nil
+}
+
+func (nt *Generic) Status() (interface{}, error) {
+	return nil, opennetzteil.ErrNotImplemented
+}
+
+func (nt *Generic) GetMaster() (bool, error) {
+	return nt.requestBool("get_master", 0)
+}
+
+func (nt *Generic) SetMaster(enabled bool) error {
+	return nt.sendBool("set_master", 0, enabled)
+}
+
+func (nt *Generic) SetBeep(enabled bool) error {
+	return nt.sendBool("set_beep", 0, enabled)
+}
+
+func (nt *Generic) Capabilities() []string {
+	var caps []string
+	if nt.cmds.SetMaster != "" {
+		caps = append(caps, opennetzteil.CapabilityMaster)
+	}
+	if nt.cmds.SetBeep != "" {
+		caps = append(caps, opennetzteil.CapabilityBeep)
+	}
+	if nt.cmds.SetOCP != "" {
+		caps = append(caps, opennetzteil.CapabilityOCP)
+	}
+	if nt.cmds.SetOVP != "" {
+		caps = append(caps, opennetzteil.CapabilityOVP)
+	}
+	return caps
+}
+
+func (nt *Generic) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	if nt.cmds.MaxVoltage == 0 || nt.cmds.MaxCurrent == 0 {
+		return opennetzteil.Rating{}, opennetzteil.ErrNotImplemented
+	}
+	return opennetzteil.Rating{MaxVoltage: nt.cmds.MaxVoltage, MaxCurrent: nt.cmds.MaxCurrent}, nil
+}
+
+func (nt *Generic) GetMode(channel int) (string, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return "", err
+	}
+	resp, err := nt.request("get_mode", channel)
+	if err != nil {
+		return "", err
+	}
+	switch resp = strings.ToUpper(resp); {
+	case strings.Contains(resp, opennetzteil.ModeCC):
+		return opennetzteil.ModeCC, nil
+	case strings.Contains(resp, opennetzteil.ModeCV):
+		return opennetzteil.ModeCV, nil
+	}
+	return "", fmt.Errorf("unexpected response to get_mode: %s", resp)
+}
+
+func (nt *Generic) GetChannels() (int, error) {
+	return nt.cmds.Channels, nil
+}
+
+func (nt *Generic) GetCurrent(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat("get_current", channel)
+}
+
+func (nt *Generic) SetCurrent(channel int, current float64) error {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return err
+	}
+	if max := nt.cmds.MaxCurrent; current < 0 || (max > 0 && current > max) {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nt.send("set_current", channel, current)
+}
+
+func (nt *Generic) GetVoltage(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat("get_voltage", channel)
+}
+
+func (nt *Generic) SetVoltage(channel int, voltage float64) error {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return err
+	}
+	if max := nt.cmds.MaxVoltage; voltage < 0 || (max > 0 && voltage > max) {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nt.send("set_voltage", channel, voltage)
+}
+
+func (nt *Generic) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat("get_voltage_setpoint", channel)
+}
+
+func (nt *Generic) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat("get_current_setpoint", channel)
+}
+
+func (nt *Generic) GetOut(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return false, err
+	}
+	return nt.requestBool("get_out", channel)
+}
+
+func (nt *Generic) SetOut(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return err
+	}
+	return nt.sendBool("set_out", channel, enabled)
+}
+
+func (nt *Generic) GetOCP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return false, err
+	}
+	return nt.requestBool("get_ocp", channel)
+}
+
+func (nt *Generic) SetOCP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return err
+	}
+	return nt.sendBool("set_ocp", channel, enabled)
+}
+
+func (nt *Generic) GetOVP(channel int) (bool, error) {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return false, err
+	}
+	return nt.requestBool("get_ovp", channel)
+}
+
+func (nt *Generic) SetOVP(channel int, enabled bool) error {
+	if err := opennetzteil.CheckChannel(channel, nt.cmds.Channels); err != nil {
+		return err
+	}
+	return nt.sendBool("set_ovp", channel, enabled)
+}
@@ -0,0 +1,103 @@
+package scpi
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// pipeFile is a connection without deadline support,
+// like a usbtmc device.
+type pipeFile struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (f *pipeFile) Close() error {
+	for _, c := range f.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// fakeFile serves a device answering MEAS:VOLT? with 12.5
+// if answer is true and staying silent otherwise.
+func fakeFile(answer bool) *pipeFile {
+	cmdR, cmdW := io.Pipe()
+	respR, respW := io.Pipe()
+	go func() {
+		defer respW.Close()
+		r := bufio.NewReader(cmdR)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if answer && strings.TrimSpace(line) == "MEAS:VOLT?" {
+				io.WriteString(respW, "12.5\n")
+			}
+		}
+	}()
+	return &pipeFile{Reader: respR, Writer: cmdW, closers: []io.Closer{cmdW, respR}}
+}
+
+func TestGenericFileTimeout(t *testing.T) {
+	nt, err := newGeneric(&Commands{MaxVoltage: 30, MaxCurrent: 3, GetVoltage: "MEAS:VOLT?"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := false
+	dials := 0
+	nt.dial = func() (io.ReadWriteCloser, error) {
+		dials++
+		return fakeFile(answer), nil
+	}
+
+	start := time.Now()
+	if _, err := nt.GetVoltage(1); !errors.Is(err, opennetzteil.ErrTimeout) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > timeout+time.Second {
+		t.Errorf("the timeout took %s", d)
+	}
+
+	answer = true
+	voltage, err := nt.GetVoltage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voltage != 12.5 {
+		t.Errorf("got %g V, want 12.5 V", voltage)
+	}
+	if dials != 2 {
+		t.Errorf("got %d connections, want 2", dials)
+	}
+	nt.Close()
+}
+
+func TestNewGenericKeepsCommands(t *testing.T) {
+	cmds := &Commands{
+		GetVoltage: "MEAS:VOLT? CH{ch}",
+		SetVoltage: "VOLT {v:.3f}",
+		Patterns:   map[string]string{"get_voltage": `([\d.]+)V`},
+	}
+	orig := cmds.Copy()
+
+	nt, err := NewGenericTCP("127.0.0.1:5025", cmds, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cmds, orig) {
+		t.Errorf("commands changed to %+v", cmds)
+	}
+	if nt.cmds.Ident != "*IDN?" || nt.cmds.Terminator != "\n" || nt.cmds.patterns["get_voltage"] == nil {
+		t.Errorf("defaults not set: %+v", nt.cmds)
+	}
+}
@@ -0,0 +1,423 @@
+package siglent
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// DefaultPort is the SCPI port of the LAN interface.
+const DefaultPort = "5025"
+
+const (
+	// The responses are not terminated; a response is
+	// complete if nothing was received for responseTimeout.
+	responseTimeout = 200 * time.Millisecond
+	// The device drops commands which are sent too fast.
+	commandDelay = 100 * time.Millisecond
+)
+
+// Tracking modes of the SPD3303 series.
+const (
+	TrackingIndependent = opennetzteil.TrackingIndependent
+	TrackingSeries      = opennetzteil.TrackingSeries
+	TrackingParallel    = opennetzteil.TrackingParallel
+)
+
+// The arguments of OUTP:TRACK are the indices.
+var trackingModes = []string{TrackingIndependent, TrackingSeries, TrackingParallel}
+
+// Bits of the SYST:STAT? response; the mode and output
+// bits of channel n are shifted by n-1.
+const (
+	statusCC       = 1 << 0
+	statusTracking = 3 << 2
+	statusOutput   = 1 << 4
+	statusTimer    = 1 << 6
+)
+
+// The tracking bits 2 and 3 of the status. Note that the
+// order differs from the one of OUTP:TRACK.
+var statusTrackingModes = map[uint64]string{
+	1 << 2: TrackingIndependent,
+	2 << 2: TrackingParallel,
+	3 << 2: TrackingSeries,
+}
+
+type model struct {
+	rating   opennetzteil.Rating
+	channels int
+	tracking bool
+}
+
+var models = map[string]model{
+	"SPD3303X": {
+		rating:   opennetzteil.Rating{MaxVoltage: 32, MaxCurrent: 3.2},
+		channels: 2,
+		tracking: true,
+	},
+	"SPD1168X": {
+		rating:   opennetzteil.Rating{MaxVoltage: 16, MaxCurrent: 8, MaxPower: 128},
+		channels: 1,
+	},
+	"SPD1305X": {
+		rating:   opennetzteil.Rating{MaxVoltage: 30, MaxCurrent: 5, MaxPower: 150},
+		channels: 1,
+	},
+}
+
+// SPD implements the Siglent SPD3303X and SPD1000X series.
+type SPD struct {
+	opennetzteil.NetzteilBase
+	target string
+	model  model
+	mutex  sync.Mutex
+	last   time.Time
+}
+
+type ChannelStatus struct {
+	ChannelMode string
+	Output      bool
+	Timer       bool
+}
+
+type Status struct {
+	// Tracking is empty if not supported.
+	Tracking string `json:",omitempty"`
+	Channels []ChannelStatus
+}
+
+// NewSPD creates a driver for a device reachable
+// via LAN; target is host:port.
+func NewSPD(target, name string) *SPD {
+	return &SPD{
+		NetzteilBase: opennetzteil.NetzteilBase{Name: name},
+		target:       target,
+	}
+}
+
+func (nt *SPD) delay() {
+	if d := commandDelay - time.Since(nt.last); d > 0 {
+		time.Sleep(d)
+	}
+	nt.last = time.Now()
+}
+
+func (nt *SPD) request(cmd string) (string, error) {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	conn, err := net.Dial("tcp", nt.target)
+	if err != nil {
+		return "", opennetzteil.TransportError(err)
+	}
+	defer conn.Close()
+
+	nt.delay()
+	resp, err := nt.RequestWithTimeout(conn, []byte(cmd+"\n"), responseTimeout)
+	if err != nil {
+		return "", err
+	}
+	if len(resp) == 0 {
+		return "", fmt.Errorf("%w: no response to '%s'", opennetzteil.ErrTimeout, cmd)
+	}
+	return strings.TrimSpace(string(resp)), nil
+}
+
+func (nt *SPD) send(cmds ...string) error {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+
+	conn, err := net.Dial("tcp", nt.target)
+	if err != nil {
+		return opennetzteil.TransportError(err)
+	}
+	defer conn.Close()
+
+	for _, cmd := range cmds {
+		nt.delay()
+		if err := nt.SendCommandLine(conn, []byte(cmd)); err != nil {
+			return err
+		}
+	}
+	// Give the device time to process the last
+	// command before the connection is closed.
+	nt.delay()
+	return nil
+}
+
+func (nt *SPD) requestFloat(cmd string) (float64, error) {
+	resp, err := nt.request(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(resp, 64)
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func (nt *SPD) Ping() error {
+	_, err := nt.request("*IDN?")
+	return err
+}
+
+// Probe identifies the model. The response of *IDN? looks like:
+// Siglent Technologies,SPD3303X-E,SPD3XXXXXXXXXX,1.01.01.02.07R2,V3.0
+func (nt *SPD) Probe() error {
+	ident, err := nt.request("*IDN?")
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(ident, ",")
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected identification: %s", ident)
+	}
+	m, ok := models[strings.TrimSuffix(fields[1], "-E")]
+	if !ok {
+		return fmt.Errorf("unsupported model: %s", fields[1])
+	}
+	nt.model = m
+	nt.SetIdent(ident)
+	return nil
+}
+
+func (nt *SPD) checkChannel(channel int) error {
+	return opennetzteil.CheckChannel(channel, nt.model.channels)
+}
+
+// status returns the bitfield of SYST:STAT?, e.g. 0x0224.
+func (nt *SPD) status() (uint64, error) {
+	resp, err := nt.request("SYST:STAT?")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimPrefix(resp, "0x"), 16, 16)
+}
+
+func decodeStatus(bits uint64, m model) Status {
+	var status Status
+	if m.tracking {
+		status.Tracking = statusTrackingModes[bits&statusTracking]
+	}
+	for ch := 0; ch < m.channels; ch++ {
+		cs := ChannelStatus{
+			ChannelMode: opennetzteil.ModeCV,
+			Output:      bits&(statusOutput<<ch) != 0,
+			Timer:       bits&(statusTimer<<ch) != 0,
+		}
+		if bits&(statusCC<<ch) != 0 {
+			cs.ChannelMode = opennetzteil.ModeCC
+		}
+		status.Channels = append(status.Channels, cs)
+	}
+	return status
+}
+
+func (nt *SPD) channelStatus(channel int) (ChannelStatus, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return ChannelStatus{}, err
+	}
+	bits, err := nt.status()
+	if err != nil {
+		return ChannelStatus{}, err
+	}
+	return decodeStatus(bits, nt.model).Channels[channel-1], nil
+}
+
+func (nt *SPD) Status() (interface{}, error) {
+	bits, err := nt.status()
+	if err != nil {
+		return nil, err
+	}
+	return decodeStatus(bits, nt.model), nil
+}
+
+// GetMaster reports whether any output is enabled;
+// the devices have no master output.
+func (nt *SPD) GetMaster() (bool, error) {
+	bits, err := nt.status()
+	if err != nil {
+		return false, err
+	}
+	for _, cs := range decodeStatus(bits, nt.model).Channels {
+		if cs.Output {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetMaster switches all outputs.
+func (nt *SPD) SetMaster(enabled bool) error {
+	var cmds []string
+	for ch := 1; ch <= nt.model.channels; ch++ {
+		cmds = append(cmds, fmt.Sprintf("OUTP CH%d,%s", ch, onOff(enabled)))
+	}
+	return nt.send(cmds...)
+}
+
+func (nt *SPD) SetBeep(enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+func (nt *SPD) Capabilities() []string {
+	caps := []string{
+		opennetzteil.CapabilityStatus,
+		opennetzteil.CapabilityMaster,
+		opennetzteil.CapabilityTimer,
+	}
+	if nt.model.tracking {
+		caps = append(caps, opennetzteil.CapabilityTracking)
+	}
+	return caps
+}
+
+func (nt *SPD) GetRating(channel int) (opennetzteil.Rating, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return opennetzteil.Rating{}, err
+	}
+	return nt.model.rating, nil
+}
+
+func (nt *SPD) GetMode(channel int) (string, error) {
+	cs, err := nt.channelStatus(channel)
+	if err != nil {
+		return "", err
+	}
+	return cs.ChannelMode, nil
+}
+
+func (nt *SPD) GetChannels() (int, error) {
+	return nt.model.channels, nil
+}
+
+func (nt *SPD) GetCurrent(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf("MEAS:CURR? CH%d", channel))
+}
+
+func (nt *SPD) SetCurrent(channel int, current float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if max := nt.model.rating.MaxCurrent; current < 0 || current > max {
+		return fmt.Errorf("%w: current %.3f A; must be 0-%g A", opennetzteil.ErrOutOfRange, current, max)
+	}
+	return nt.send(fmt.Sprintf("CH%d:CURR %.3f", channel, current))
+}
+
+func (nt *SPD) GetVoltage(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf("MEAS:VOLT? CH%d", channel))
+}
+
+func (nt *SPD) SetVoltage(channel int, voltage float64) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if max := nt.model.rating.MaxVoltage; voltage < 0 || voltage > max {
+		return fmt.Errorf("%w: voltage %.3f V; must be 0-%g V", opennetzteil.ErrOutOfRange, voltage, max)
+	}
+	return nt.send(fmt.Sprintf("CH%d:VOLT %.3f", channel, voltage))
+}
+
+func (nt *SPD) GetVoltageSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf("CH%d:VOLT?", channel))
+}
+
+func (nt *SPD) GetCurrentSetpoint(channel int) (float64, error) {
+	if err := nt.checkChannel(channel); err != nil {
+		return 0, err
+	}
+	return nt.requestFloat(fmt.Sprintf("CH%d:CURR?", channel))
+}
+
+func (nt *SPD) GetOut(channel int) (bool, error) {
+	cs, err := nt.channelStatus(channel)
+	if err != nil {
+		return false, err
+	}
+	return cs.Output, nil
+}
+
+func (nt *SPD) SetOut(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf("OUTP CH%d,%s", channel, onOff(enabled)))
+}
+
+// SetTracking sets the tracking mode of the SPD3303 series; mode is
+// one of TrackingIndependent, TrackingSeries, or TrackingParallel.
+func (nt *SPD) SetTracking(mode string) error {
+	if !nt.model.tracking {
+		return opennetzteil.ErrNotImplemented
+	}
+	for i, m := range trackingModes {
+		if m == mode {
+			return nt.send(fmt.Sprintf("OUTP:TRACK %d", i))
+		}
+	}
+	return fmt.Errorf("%w: tracking mode '%s'", opennetzteil.ErrOutOfRange, mode)
+}
+
+// TimerStep is a step of the timer mode.
+type TimerStep = opennetzteil.TimerStep
+
+// SetTimer configures the timer mode of channel. The device
+// supports up to five steps with a duration of whole seconds.
+func (nt *SPD) SetTimer(channel int, steps []TimerStep) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	if len(steps) > 5 {
+		return fmt.Errorf("%w: %d timer steps; must be 0-5", opennetzteil.ErrOutOfRange, len(steps))
+	}
+	var cmds []string
+	for i, s := range steps {
+		cmd := fmt.Sprintf("TIME:SET CH%d,%d,%.3f,%.3f,%d", channel, i+1, s.Voltage, s.Current, int(s.Duration.Seconds()))
+		cmds = append(cmds, cmd)
+	}
+	return nt.send(cmds...)
+}
+
+// EnableTimer starts or stops the timer mode of channel.
+func (nt *SPD) EnableTimer(channel int, enabled bool) error {
+	if err := nt.checkChannel(channel); err != nil {
+		return err
+	}
+	return nt.send(fmt.Sprintf("TIME CH%d,%s", channel, onOff(enabled)))
+}
+
+func (nt *SPD) GetOCP(channel int) (bool, error) {
+	return false, opennetzteil.ErrNotImplemented
+}
+
+func (nt *SPD) SetOCP(channel int, enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
+
+func (nt *SPD) GetOVP(channel int) (bool, error) {
+	return false, opennetzteil.ErrNotImplemented
+}
+
+func (nt *SPD) SetOVP(channel int, enabled bool) error {
+	return opennetzteil.ErrNotImplemented
+}
@@ -0,0 +1,34 @@
+package siglent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+func TestDecodeStatus(t *testing.T) {
+	var (
+		cv  = opennetzteil.ModeCV
+		cc  = opennetzteil.ModeCC
+		spd = models["SPD3303X"]
+	)
+	tests := []struct {
+		bits   uint64
+		model  model
+		status Status
+	}{
+		{0x0004, spd, Status{Tracking: TrackingIndependent, Channels: []ChannelStatus{{cv, false, false}, {cv, false, false}}}},
+		{0x0008, spd, Status{Tracking: TrackingParallel, Channels: []ChannelStatus{{cv, false, false}, {cv, false, false}}}},
+		{0x000c, spd, Status{Tracking: TrackingSeries, Channels: []ChannelStatus{{cv, false, false}, {cv, false, false}}}},
+		{0x0000, spd, Status{Channels: []ChannelStatus{{cv, false, false}, {cv, false, false}}}},
+		{0x0015, spd, Status{Tracking: TrackingIndependent, Channels: []ChannelStatus{{cc, true, false}, {cv, false, false}}}},
+		{0x00a6, spd, Status{Tracking: TrackingIndependent, Channels: []ChannelStatus{{cv, false, false}, {cc, true, true}}}},
+		{0x0011, models["SPD1305X"], Status{Channels: []ChannelStatus{{cc, true, false}}}},
+	}
+	for _, tc := range tests {
+		if got := decodeStatus(tc.bits, tc.model); !reflect.DeepEqual(got, tc.status) {
+			t.Errorf("decodeStatus(%#04x) = %+v, want %+v", tc.bits, got, tc.status)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package opennetzteil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Errors returned by drivers. Drivers wrap them with further
+// details; use errors.Is() to check for them. The HTTP server
+// maps them to the appropriate status codes.
+var (
+	ErrNotImplemented    = errors.New("endpoint not implemented")
+	ErrInvalidChannel    = errors.New("invalid channel")
+	ErrOutOfRange        = errors.New("value out of range")
+	ErrTimeout           = errors.New("device timeout")
+	ErrBusy              = errors.New("device busy")
+	ErrProtectionTripped = errors.New("protection tripped")
+	ErrTransport         = errors.New("transport failure")
+	ErrDeviceNotFound    = errors.New("device does not exist")
+)
+
+// transportError keeps the underlying I/O error available
+// for errors.Is(), e.g. for syscall.EIO.
+type transportError struct {
+	kind error
+	err  error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func (e *transportError) Is(target error) bool {
+	return target == e.kind
+}
+
+// TransportError wraps an I/O error of the connection to a device
+// as ErrTimeout or ErrTransport.
+func TransportError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrTransport):
+		return err
+	case os.IsTimeout(err):
+		return &transportError{kind: ErrTimeout, err: err}
+	}
+	return &transportError{kind: ErrTransport, err: err}
+}
+
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrNotImplemented, http.StatusNotImplemented, "not_implemented"},
+	{ErrInvalidChannel, http.StatusNotFound, "invalid_channel"},
+	{ErrDeviceNotFound, http.StatusNotFound, "device_not_found"},
+	{ErrOutOfRange, http.StatusUnprocessableEntity, "out_of_range"},
+	{ErrTimeout, http.StatusGatewayTimeout, "timeout"},
+	{ErrBusy, http.StatusConflict, "busy"},
+	{ErrProtectionTripped, http.StatusConflict, "protection_tripped"},
+	{ErrDeviceOffline, http.StatusServiceUnavailable, "device_offline"},
+	{ErrTransport, http.StatusServiceUnavailable, "transport"},
+}
+
+// apiError is the body of all error responses.
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func sendAPIError(w http.ResponseWriter, msg, code string, status int) {
+	bs, _ := json.Marshal(apiError{Error: msg, Code: code})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\n", bs)
+}
+
+func lookupError(err error) (string, int) {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code, e.status
+		}
+	}
+	return "internal", http.StatusInternalServerError
+}
+
+// errorBody returns the error body for err, e.g. for streams.
+func errorBody(err error) apiError {
+	code, _ := lookupError(err)
+	return apiError{Error: err.Error(), Code: code}
+}
+
+// sendError maps err to a HTTP status code. Unknown errors
+// are reported as internal server errors.
+func sendError(w http.ResponseWriter, err error) {
+	code, status := lookupError(err)
+	sendAPIError(w, err.Error(), code, status)
+}
+
+// sendErrorStatus reports an error which is not caused by the
+// device, e.g. an invalid request body.
+func sendErrorStatus(w http.ResponseWriter, msg string, status int) {
+	var code string
+	switch status {
+	case http.StatusBadRequest:
+		code = "bad_request"
+	case http.StatusUnauthorized:
+		code = "unauthorized"
+	case http.StatusForbidden:
+		code = "forbidden"
+	case http.StatusNotFound:
+		code = "not_found"
+	case http.StatusLocked:
+		code = "locked"
+	case http.StatusNotImplemented:
+		code = "not_implemented"
+	default:
+		code = "internal"
+	}
+	sendAPIError(w, msg, code, status)
+}
@@ -0,0 +1,235 @@
+package opennetzteil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Event types published by devices.
+const (
+	// EventOutput is published when an output was switched.
+	// Channel 0 refers to the master output.
+	EventOutput = "output"
+	// EventSetpoint is published when a setpoint was changed.
+	EventSetpoint = "setpoint"
+	// EventPreset is published when a preset was recalled;
+	// the value is the number of the preset.
+	EventPreset = "preset"
+	// EventProtection is published when the over current or
+	// over voltage protection of a channel tripped.
+	EventProtection = "protection"
+	// EventState is published when the health state changed.
+	EventState = "state"
+)
+
+// Event is a change of a device. The type of Value
+// depends on Type.
+type Event struct {
+	Type    string      `json:"type"`
+	Device  string      `json:"device"`
+	Channel int         `json:"channel"`
+	Time    time.Time   `json:"time"`
+	Value   interface{} `json:"value"`
+	// Client is the identity of the client which caused
+	// the event, if known.
+	Client string `json:"client,omitempty"`
+
+	dev *Device
+}
+
+// eventBus distributes events to all subscribers. Slow subscribers
+// lose events instead of blocking the publisher.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func (b *eventBus) subscribe() chan Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	ch := make(chan Event, 32)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publish sends an event of the device. It must not
+// be called with d.mutex held.
+func (d *Device) publish(a actor, typ string, channel int, value interface{}) {
+	if d.events == nil {
+		return
+	}
+	d.events.publish(Event{
+		Type:    typ,
+		Device:  d.label(),
+		Channel: channel,
+		Time:    time.Now(),
+		Value:   value,
+		Client:  a.Name,
+		dev:     d,
+	})
+}
+
+// checkProtection publishes an event for each channel
+// whose protection tripped since the last check.
+func (d *Device) checkProtection() error {
+	drv, err := d.driver()
+	if err != nil {
+		return err
+	}
+	p, ok := drv.(ProtectionReporter)
+	if !ok {
+		return nil
+	}
+	nChannels, err := drv.GetChannels()
+	if err != nil {
+		return err
+	}
+	for ch := 1; ch <= nChannels; ch++ {
+		tripped, err := p.GetProtectionTripped(ch)
+		if err != nil {
+			return err
+		}
+		d.mutex.Lock()
+		if d.tripped == nil {
+			d.tripped = make(map[int]bool)
+		}
+		changed := d.tripped[ch] != tripped
+		d.tripped[ch] = tripped
+		d.mutex.Unlock()
+		if changed && tripped {
+			d.publish(actor{}, EventProtection, ch, tripped)
+		}
+	}
+	return nil
+}
+
+// sseWriter writes server-sent events (text/event-stream).
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+func (s *sseWriter) send(event string, data interface{}) error {
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, bs); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// getMeasurementsSSE returns a handler which streams
+// measurements of type mtype as server-sent events.
+func (s *HTTPServer) getMeasurementsSSE(mtype int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dev, channel, err := s.lookupDevAndParseChannel(w, r)
+		if err != nil {
+			return
+		}
+		interval, err := strconv.ParseUint(r.URL.Query().Get("interval"), 10, 32)
+		if err != nil {
+			sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sse, err := newSSEWriter(w)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		period := time.Duration(interval) * time.Millisecond
+		if period <= 0 {
+			period = time.Millisecond
+		}
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			m, err := measure(dev, channel, mtype)
+			if err != nil {
+				err = sse.send("error", errorBody(err))
+			} else {
+				err = sse.send("measurement", m)
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func (s *HTTPServer) getEvents(w http.ResponseWriter, r *http.Request) {
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	events := s.Devices.events.subscribe()
+	defer s.Devices.events.unsubscribe(events)
+
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if e.dev != d {
+				continue
+			}
+			if err := sse.send(e.Type, e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package opennetzteil_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+// fakeSCPI serves a line based protocol on a local TCP port and
+// returns its address. reply returns the response to a command;
+// nothing is sent for an empty response.
+func fakeSCPI(t *testing.T, reply func(cmd string) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if resp := reply(strings.TrimSpace(line)); resp != "" {
+						io.WriteString(conn, resp+"\n")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// scpiReplies answers the commands in replies by prefix and all
+// other queries with 0.
+func scpiReplies(replies map[string]string) func(string) string {
+	return func(cmd string) string {
+		var (
+			resp   string
+			prefix string
+		)
+		for p, r := range replies {
+			if strings.HasPrefix(cmd, p) && len(p) > len(prefix) {
+				resp, prefix = r, p
+			}
+		}
+		if prefix == "" && strings.Contains(cmd, "?") {
+			return "0"
+		}
+		return resp
+	}
+}
+
+// fakePTY runs a device behind a pseudo terminal and returns the
+// path of the slave. serve is called with the master side.
+func fakePTY(t *testing.T, serve func(rw io.ReadWriter)) string {
+	master, slave, err := openPTY()
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		master.Close()
+		slave.Close()
+	})
+	go serve(master)
+	return slave.Name()
+}
+
+var rndQuery = regexp.MustCompile(`\*IDN\?|STATUS\?|[VI](OUT|SET)\d\?`)
+
+// fakeRND320 answers the queries of the KA3005 protocol. The commands
+// are not terminated; they are separated by the pauses in between.
+func fakeRND320(replies map[string]string) func(io.ReadWriter) {
+	return func(rw io.ReadWriter) {
+		buf := make([]byte, 1024)
+		for {
+			n, err := rw.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, q := range rndQuery.FindAllString(string(buf[:n]), -1) {
+				if resp, ok := replies[q]; ok {
+					io.WriteString(rw, resp)
+				}
+			}
+		}
+	}
+}
+
+// fakeModbus is a Modbus server with a single register space for
+// holding and input registers.
+type fakeModbus struct {
+	mutex sync.Mutex
+	regs  map[uint16]uint16
+	coils map[uint16]bool
+}
+
+func newFakeModbus(regs map[uint16]uint16) *fakeModbus {
+	if regs == nil {
+		regs = make(map[uint16]uint16)
+	}
+	return &fakeModbus{regs: regs, coils: make(map[uint16]bool)}
+}
+
+// handle processes the PDU of a request and returns the PDU of the response.
+func (m *fakeModbus) handle(pdu []byte) []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var (
+		function = pdu[0]
+		addr     = binary.BigEndian.Uint16(pdu[1:])
+		value    = binary.BigEndian.Uint16(pdu[3:])
+	)
+	switch function {
+	case 0x01:
+		bits := make([]byte, (value+7)/8)
+		for i := uint16(0); i < value; i++ {
+			if m.coils[addr+i] {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{function, byte(len(bits))}, bits...)
+	case 0x03, 0x04:
+		resp := []byte{function, byte(2 * value)}
+		for i := uint16(0); i < value; i++ {
+			resp = append(resp, byte(m.regs[addr+i]>>8), byte(m.regs[addr+i]))
+		}
+		return resp
+	case 0x05:
+		m.coils[addr] = value == 0xff00
+		return pdu[:5]
+	case 0x06:
+		m.regs[addr] = value
+		return pdu[:5]
+	case 0x10:
+		for i := uint16(0); i < value; i++ {
+			m.regs[addr+i] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		return pdu[:5]
+	}
+	return []byte{function | 0x80, 1}
+}
+
+// serveTCP serves Modbus TCP on a local port and returns its address.
+func (m *fakeModbus) serveTCP(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					header := make([]byte, 7)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+					if _, err := io.ReadFull(conn, pdu); err != nil {
+						return
+					}
+					resp := m.handle(pdu)
+					binary.BigEndian.PutUint16(header[4:], uint16(len(resp)+1))
+					conn.Write(append(header, resp...))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// serveRTU serves Modbus RTU on rw, e.g. the master side of a pty.
+func (m *fakeModbus) serveRTU(rw io.ReadWriter) {
+	for {
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(rw, req); err != nil {
+			return
+		}
+		if req[1] == 0x10 {
+			// The byte count follows the register count.
+			rest := make([]byte, int(req[6])+1)
+			if _, err := io.ReadFull(rw, rest); err != nil {
+				return
+			}
+			req = append(req, rest...)
+		}
+		resp := append([]byte{req[0]}, m.handle(req[1:len(req)-2])...)
+		crc := opennetzteil.CRC16(resp)
+		rw.Write(append(resp, byte(crc), byte(crc>>8)))
+	}
+}
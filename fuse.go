@@ -0,0 +1,105 @@
+package opennetzteil
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// fuse returns the driver of d if it has electronic fuses.
+func (d *Device) fuse() (ElectronicFuse, error) {
+	drv, err := d.driver()
+	if err != nil {
+		return nil, err
+	}
+	f, ok := drv.(ElectronicFuse)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return f, nil
+}
+
+func (d *Device) setFuseDelay(a actor, channel int, delay time.Duration) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		f, err := d.fuse()
+		if err != nil {
+			return err
+		}
+		err = f.SetFuseDelay(channel, delay)
+		d.audit(a, "SetFuseDelay", channel, nil, delay.Milliseconds(), err)
+		return err
+	})
+}
+
+func (d *Device) linkFuse(a actor, channel, other int, link bool) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		f, err := d.fuse()
+		if err != nil {
+			return err
+		}
+		if link {
+			err = f.LinkFuse(channel, other)
+			d.audit(a, "LinkFuse", channel, nil, other, err)
+		} else {
+			err = f.UnlinkFuse(channel, other)
+			d.audit(a, "UnlinkFuse", channel, nil, other, err)
+		}
+		return err
+	})
+}
+
+func (s *HTTPServer) getFuseDelay(w http.ResponseWriter, r *http.Request) {
+	d, channel, err := s.lookupDeviceChannel(w, r)
+	if err != nil {
+		return
+	}
+	f, err := d.fuse()
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	delay, err := f.GetFuseDelay(channel)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, delay.Milliseconds())
+}
+
+func (s *HTTPServer) putFuseDelay(w http.ResponseWriter, r *http.Request) {
+	var req int64
+	d, channel, err := s.lookupDeviceChannel(w, r)
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.setFuseDelay(requestActor(r), channel, time.Duration(req)*time.Millisecond); err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) fuseLink(link bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, channel, err := s.lookupDeviceChannel(w, r)
+		if err != nil {
+			return
+		}
+		other, err := strconv.Atoi(mux.Vars(r)["other"])
+		if err != nil {
+			sendError(w, fmt.Errorf("%w: '%s'", ErrInvalidChannel, mux.Vars(r)["other"]))
+			return
+		}
+		if err := d.linkFuse(requestActor(r), channel, other, link); err != nil {
+			sendError(w, err)
+			return
+		}
+	}
+}
@@ -13,6 +13,7 @@ require (
 	github.com/pelletier/go-toml v1.9.5
 	github.com/rumpelsepp/helpers v0.0.0-20220516154105-beca9ef07c0c
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20220513210258-46612604a0f9
 )
 
 require (
@@ -23,6 +24,5 @@ require (
 	github.com/mattn/go-runewidth v0.0.2 // indirect
 	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/nsf/termbox-go v0.0.0-20190121233118-02980233997d // indirect
-	golang.org/x/crypto v0.0.0-20220513210258-46612604a0f9 // indirect
 	golang.org/x/sys v0.0.0-20220513210249-45d2b4557a2a // indirect
 )
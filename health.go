@@ -0,0 +1,395 @@
+package opennetzteil
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+type DeviceState string
+
+const (
+	StateOnline DeviceState = "online"
+	// StateDegraded indicates failed health checks. The
+	// device is still usable but might go offline soon.
+	StateDegraded DeviceState = "degraded"
+	StateOffline  DeviceState = "offline"
+)
+
+// maxHealthFailures is the number of consecutive failed
+// health checks after which a device is considered offline.
+const maxHealthFailures = 3
+
+type Health struct {
+	State      DeviceState `json:"state"`
+	Error      string      `json:"error,omitempty"`
+	LastSeen   time.Time   `json:"last_seen"`
+	Failures   int         `json:"failures"`
+	Reconnects int         `json:"reconnects"`
+}
+
+func (d *Device) Health() Health {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.health
+}
+
+func (d *Device) State() DeviceState {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.health.State
+}
+
+// ping checks whether the device responds. Probe() must not be used;
+// it modifies the driver state while handlers might use the driver.
+func ping(nt Netzteil) error {
+	if p, ok := nt.(Pinger); ok {
+		return p.Ping()
+	}
+	_, err := nt.GetMaster()
+	if errors.Is(err, ErrNotImplemented) {
+		return nil
+	}
+	return err
+}
+
+// check pings an online device and updates its health state.
+func (d *Device) check() error {
+	d.mutex.Lock()
+	nt := d.nt
+	d.mutex.Unlock()
+	if nt == nil {
+		return ErrDeviceOffline
+	}
+
+	err := ping(nt)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// The device was closed in the meantime.
+	if d.nt != nt {
+		return err
+	}
+	if err == nil {
+		d.ident = rawIdent(nt)
+		d.health.State = StateOnline
+		d.health.Error = ""
+		d.health.Failures = 0
+		d.health.LastSeen = time.Now()
+		return nil
+	}
+	d.health.Failures++
+	d.health.Error = err.Error()
+	if d.health.Failures < maxHealthFailures {
+		d.health.State = StateDegraded
+		return err
+	}
+	closeNetzteil(d.nt)
+	d.nt = nil
+	d.health.State = StateOffline
+	return err
+}
+
+func (d *Device) supervise(r *Registry) {
+	var (
+		backoff = r.RetryInterval
+		wait    = r.HealthInterval
+	)
+	if d.State() == StateOffline {
+		wait = backoff
+	}
+
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if state := d.State(); state != StateOffline {
+			if err := d.check(); err != nil {
+				r.Logger.LogWarningf("device %s: health check failed: %s", d.Key, err)
+			} else if err := d.switchOffPending(); err != nil {
+				r.Logger.LogErrorf("device %s: switching off expired leases failed: %s", d.Key, err)
+			} else if err := d.checkProtection(); err != nil {
+				r.Logger.LogWarningf("device %s: protection check failed: %s", d.Key, err)
+			}
+			if newState := d.State(); newState != state {
+				d.publish(actor{}, EventState, 0, newState)
+			}
+			if d.State() == StateOffline {
+				r.Logger.LogErrorf("device %s is offline", d.Key)
+				backoff = r.RetryInterval
+				wait = backoff
+				continue
+			}
+			wait = r.HealthInterval
+			continue
+		}
+
+		if err := d.connect(); err != nil {
+			r.Logger.LogDebugf("device %s still offline: %s", d.Key, err)
+			backoff *= 2
+			if backoff > r.MaxRetryInterval {
+				backoff = r.MaxRetryInterval
+			}
+			wait = backoff
+			continue
+		}
+		r.Logger.LogInfof("device %s is online", d.Key)
+		d.publish(actor{}, EventState, 0, StateOnline)
+		if err := d.switchOffPending(); err != nil {
+			r.Logger.LogErrorf("device %s: switching off expired leases failed: %s", d.Key, err)
+		}
+		if d.RestoreSetpoints {
+			if err := d.restore(); err != nil {
+				r.Logger.LogErrorf("device %s: restoring setpoints failed: %s", d.Key, err)
+			}
+		}
+		wait = r.HealthInterval
+	}
+}
+
+// channelSetpoints are the last values set via Device.Netzteil().
+// Channel 0 refers to the master output.
+// They are also used as the request body of bulk updates.
+type channelSetpoints struct {
+	Voltage *float64 `json:"voltage,omitempty"`
+	Current *float64 `json:"current,omitempty"`
+	OCP     *bool    `json:"ocp,omitempty"`
+	OVP     *bool    `json:"ovp,omitempty"`
+	Out     *bool    `json:"out,omitempty"`
+}
+
+func (d *Device) record(channel int, f func(*channelSetpoints)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.setpoints == nil {
+		d.setpoints = make(map[int]*channelSetpoints)
+	}
+	sp, ok := d.setpoints[channel]
+	if !ok {
+		sp = &channelSetpoints{}
+		d.setpoints[channel] = sp
+	}
+	f(sp)
+}
+
+// deferSwitchOff records the output of channel as off and switches it
+// off once the device is reachable again. It is used for leases which
+// expired while the device was offline; otherwise, restored setpoints
+// would switch the output on again.
+func (d *Device) deferSwitchOff(a actor, channel int) {
+	off := false
+	d.record(channel, func(sp *channelSetpoints) { sp.Out = &off })
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pendingOff == nil {
+		d.pendingOff = make(map[int]actor)
+	}
+	d.pendingOff[channel] = a
+}
+
+// switchOffPending switches off the outputs recorded by
+// deferSwitchOff. Failed outputs are kept pending.
+func (d *Device) switchOffPending() error {
+	d.mutex.Lock()
+	pending := d.pendingOff
+	d.pendingOff = nil
+	d.mutex.Unlock()
+
+	var firstErr error
+	for ch, a := range pending {
+		nt, err := d.netzteilAs(a)
+		if err == nil {
+			if ch == MasterChannel {
+				err = nt.SetMaster(false)
+			} else {
+				err = nt.SetOut(ch, false)
+			}
+		}
+		if err != nil {
+			d.deferSwitchOff(a, ch)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// restore re-applies the recorded setpoints. Limits are applied
+// first; outputs are switched on afterwards, the master output last.
+func (d *Device) restore() error {
+	d.txMutex.Lock()
+	defer d.txMutex.Unlock()
+
+	d.mutex.Lock()
+	var (
+		nt  = d.nt
+		sps = make(map[int]channelSetpoints)
+	)
+	for ch, sp := range d.setpoints {
+		sps[ch] = *sp
+	}
+	d.mutex.Unlock()
+	if nt == nil {
+		return ErrDeviceOffline
+	}
+	return applySetpoints(nt, sps)
+}
+
+// recordingNetzteil records all successfully applied setpoints
+// of the wrapped driver in its Device. All state-changing commands
+// are audited and serialized with Device.transaction(); queries are
+// passed through.
+type recordingNetzteil struct {
+	Netzteil
+	d     *Device
+	actor actor
+	// tx is set within Device.transaction(),
+	// which holds the txMutex already.
+	tx bool
+}
+
+// lock acquires the txMutex of the device unless
+// called within a transaction. It returns the unlock function.
+func (n *recordingNetzteil) lock() func() {
+	if n.tx {
+		return func() {}
+	}
+	n.d.txMutex.Lock()
+	return n.d.txMutex.Unlock
+}
+
+// oldBool returns the value before a command for the audit log. It is
+// read from the device; if the device cannot report it, the recorded
+// value last set via the API is used, which is nil if unknown.
+func oldBool(read func() (bool, error), recorded *bool) interface{} {
+	if v, err := read(); err == nil {
+		return v
+	}
+	return recorded
+}
+
+// oldVoltage is like oldBool for the voltage setpoint.
+func (n *recordingNetzteil) oldVoltage(channel int) interface{} {
+	if r, ok := n.Netzteil.(SetpointReader); ok {
+		if v, err := r.GetVoltageSetpoint(channel); err == nil {
+			return v
+		}
+	}
+	return n.d.setpoint(channel).Voltage
+}
+
+// oldCurrent is like oldBool for the current setpoint.
+func (n *recordingNetzteil) oldCurrent(channel int) interface{} {
+	if r, ok := n.Netzteil.(SetpointReader); ok {
+		if v, err := r.GetCurrentSetpoint(channel); err == nil {
+			return v
+		}
+	}
+	return n.d.setpoint(channel).Current
+}
+
+func (n *recordingNetzteil) SetMaster(enabled bool) error {
+	defer n.lock()()
+	old := oldBool(n.Netzteil.GetMaster, n.d.setpoint(MasterChannel).Out)
+	err := n.Netzteil.SetMaster(enabled)
+	n.d.audit(n.actor, "SetMaster", MasterChannel, old, enabled, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(MasterChannel, func(sp *channelSetpoints) { sp.Out = &enabled })
+	n.d.publish(n.actor, EventOutput, MasterChannel, enabled)
+	return nil
+}
+
+func (n *recordingNetzteil) SetBeep(enabled bool) error {
+	defer n.lock()()
+	err := n.Netzteil.SetBeep(enabled)
+	n.d.audit(n.actor, "SetBeep", MasterChannel, nil, enabled, err)
+	return err
+}
+
+func (n *recordingNetzteil) SetCurrent(channel int, current float64) error {
+	defer n.lock()()
+	old := n.oldCurrent(channel)
+	err := n.Netzteil.SetCurrent(channel, current)
+	n.d.audit(n.actor, "SetCurrent", channel, old, current, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(channel, func(sp *channelSetpoints) { sp.Current = &current })
+	n.d.publish(n.actor, EventSetpoint, channel, channelSetpoints{Current: &current})
+	return nil
+}
+
+func (n *recordingNetzteil) SetVoltage(channel int, voltage float64) error {
+	defer n.lock()()
+	old := n.oldVoltage(channel)
+	err := n.Netzteil.SetVoltage(channel, voltage)
+	n.d.audit(n.actor, "SetVoltage", channel, old, voltage, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(channel, func(sp *channelSetpoints) { sp.Voltage = &voltage })
+	n.d.publish(n.actor, EventSetpoint, channel, channelSetpoints{Voltage: &voltage})
+	return nil
+}
+
+func (n *recordingNetzteil) SetOut(channel int, enabled bool) error {
+	defer n.lock()()
+	old := oldBool(func() (bool, error) { return n.Netzteil.GetOut(channel) }, n.d.setpoint(channel).Out)
+	err := n.Netzteil.SetOut(channel, enabled)
+	n.d.audit(n.actor, "SetOut", channel, old, enabled, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(channel, func(sp *channelSetpoints) { sp.Out = &enabled })
+	n.d.publish(n.actor, EventOutput, channel, enabled)
+	return nil
+}
+
+func (n *recordingNetzteil) SetOCP(channel int, enabled bool) error {
+	defer n.lock()()
+	old := oldBool(func() (bool, error) { return n.Netzteil.GetOCP(channel) }, n.d.setpoint(channel).OCP)
+	err := n.Netzteil.SetOCP(channel, enabled)
+	n.d.audit(n.actor, "SetOCP", channel, old, enabled, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(channel, func(sp *channelSetpoints) { sp.OCP = &enabled })
+	n.d.publish(n.actor, EventSetpoint, channel, channelSetpoints{OCP: &enabled})
+	return nil
+}
+
+func (n *recordingNetzteil) SetOVP(channel int, enabled bool) error {
+	defer n.lock()()
+	old := oldBool(func() (bool, error) { return n.Netzteil.GetOVP(channel) }, n.d.setpoint(channel).OVP)
+	err := n.Netzteil.SetOVP(channel, enabled)
+	n.d.audit(n.actor, "SetOVP", channel, old, enabled, err)
+	if err != nil {
+		return err
+	}
+	n.d.record(channel, func(sp *channelSetpoints) { sp.OVP = &enabled })
+	n.d.publish(n.actor, EventSetpoint, channel, channelSetpoints{OVP: &enabled})
+	return nil
+}
+
+func (s *HTTPServer) getHealth(w http.ResponseWriter, r *http.Request) {
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	helpers.SendJSON(w, d.Health())
+}
@@ -17,8 +17,44 @@ import (
 
 type HTTPServer struct {
 	ReqLog  io.Writer
-	Devices []Netzteil
+	Devices *Registry
 	Logger  *penlogger.Logger
+	// Auth enables the built-in authentication if set.
+	Auth *Authenticator
+	// Reload is called by the admin reload endpoint.
+	Reload func() error
+	// Loads are the electronic loads; optional.
+	Loads *LoadRegistry
+
+	leases *leaseManager
+	locks  *lockManager
+}
+
+// MIMEDeviceList is the media type of the detailed device listing.
+// Clients opt in via the Accept header; the plain listing is a list
+// of ident strings.
+const MIMEDeviceList = "application/vnd.netzteil.devices+json"
+
+type deviceInfo struct {
+	ID           string      `json:"id"`
+	Index        int         `json:"index"`
+	Name         string      `json:"name"`
+	Model        string      `json:"model"`
+	Handle       string      `json:"handle"`
+	Ident        string      `json:"ident"`
+	IDN          Ident       `json:"idn"`
+	State        DeviceState `json:"state"`
+	Channels     int         `json:"channels"`
+	Capabilities []string    `json:"capabilities"`
+	Ratings      []Rating    `json:"ratings"`
+	Lock         *lock       `json:"lock"`
+}
+
+// channelInfo describes a channel in the v2 channel list.
+// The rating is null if the driver does not know it.
+type channelInfo struct {
+	Index  int     `json:"index"`
+	Rating *Rating `json:"rating"`
 }
 
 type measurement struct {
@@ -27,51 +63,89 @@ type measurement struct {
 	Time    time.Time `json:"time"`
 }
 
-func (s *HTTPServer) lookupDevice(w http.ResponseWriter, vars map[string]string) (Netzteil, error) {
-	id, err := strconv.Atoi(vars["id"])
+// lookupDeviceEntry resolves the device id in vars. The canonical
+// id of the device is returned as well; this is the stable id of the
+// device if available, or its position in the device list otherwise.
+func (s *HTTPServer) lookupDeviceEntry(w http.ResponseWriter, vars map[string]string) (*Device, string, error) {
+	d, pos, err := s.Devices.Lookup(vars["id"])
+	if err != nil {
+		sendError(w, err)
+		return nil, "", err
+	}
+	return d, canonicalID(d, pos), nil
+}
+
+func canonicalID(d *Device, pos int) string {
+	if id := d.ID(); id != "" {
+		return id
+	}
+	return strconv.Itoa(pos)
+}
+
+// deviceID returns the canonical id of d, or false if d
+// is no longer in the device list.
+func (s *HTTPServer) deviceID(d *Device) (string, bool) {
+	for i, o := range s.Devices.Devices() {
+		if o == d {
+			return canonicalID(d, i+1), true
+		}
+	}
+	return "", false
+}
+
+func (s *HTTPServer) lookupDevice(w http.ResponseWriter, r *http.Request) (Netzteil, error) {
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusNotFound)
 		return nil, err
 	}
-	// Opennetzteil ids start with 1.
-	// Thus it need to be decremented for the list lookup.
-	id--
-	if id < 0 || len(s.Devices)-1 < id {
-		err := fmt.Errorf("device does not exist")
-		helpers.SendJSONError(w, err.Error(), http.StatusNotFound)
+	dev, err := d.netzteilAs(requestActor(r))
+	if err != nil {
+		sendError(w, err)
 		return nil, err
 	}
-	return s.Devices[id], nil
+	return dev, nil
 }
 
 func parseChannel(vars map[string]string) (int, error) {
 	channel, err := strconv.Atoi(vars["channel"])
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidChannel, vars["channel"])
 	}
 	return channel, nil
 }
 
-func (s *HTTPServer) lookupDevAndParseChannel(w http.ResponseWriter, vars map[string]string) (Netzteil, int, error) {
-	dev, err := s.lookupDevice(w, vars)
-	// TODO: more different error types
+// lookupDevAndParseChannel resolves the device and the channel
+// in vars. Only the channels 1 to GetChannels() are valid.
+func (s *HTTPServer) lookupDevAndParseChannel(w http.ResponseWriter, r *http.Request) (Netzteil, int, error) {
+	return s.lookupChannel(w, r, false)
+}
+
+// lookupDevAndParseOutChannel is like lookupDevAndParseChannel,
+// but accepts MasterChannel as well.
+func (s *HTTPServer) lookupDevAndParseOutChannel(w http.ResponseWriter, r *http.Request) (Netzteil, int, error) {
+	return s.lookupChannel(w, r, true)
+}
+
+func (s *HTTPServer) lookupChannel(w http.ResponseWriter, r *http.Request, allowMaster bool) (Netzteil, int, error) {
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return nil, 0, err
 	}
-	nChannels, err := dev.GetChannels()
+	channel, err := parseChannel(mux.Vars(r))
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return nil, 0, err
 	}
-	channel, err := strconv.Atoi(vars["channel"])
-	// TODO: more different error types
+	if allowMaster && channel == MasterChannel {
+		return dev, channel, nil
+	}
+	nChannels, err := dev.GetChannels()
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusNotFound)
+		sendError(w, err)
 		return nil, 0, err
 	}
-	if channel > nChannels {
-		err := fmt.Errorf("no such channel '%d'; device has '%d' channels", channel, nChannels)
-		helpers.SendJSONError(w, err.Error(), http.StatusNotFound)
+	if err := CheckChannel(channel, nChannels); err != nil {
+		sendError(w, err)
 		return nil, 0, err
 	}
 	return dev, channel, nil
@@ -89,11 +163,28 @@ func readLoop(c *websocket.Conn) {
 
 // Handlers for full API
 func (s *HTTPServer) getDevices(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), MIMEDeviceList) {
+		s.getDevicesDetailed(w, r)
+		return
+	}
+
 	var resp []string
-	for _, dev := range s.Devices {
+	for i, d := range s.Devices.Devices() {
+		// Keep offline and inaccessible devices in the list;
+		// otherwise the positional ids would be shifted.
+		if !s.mayAccessDevice(r, strconv.Itoa(i+1)) {
+			resp = append(resp, "")
+			continue
+		}
+		dev, err := d.Netzteil()
+		if err != nil {
+			resp = append(resp, "")
+			continue
+		}
 		ident, err := dev.GetIdent()
 		if err != nil {
-			// TODO: logging, or failing out?
+			s.Logger.LogWarningf("device %d: %s", i+1, err)
+			resp = append(resp, "")
 			continue
 		}
 		resp = append(resp, ident)
@@ -102,31 +193,138 @@ func (s *HTTPServer) getDevices(w http.ResponseWriter, r *http.Request) {
 	helpers.SendJSON(w, resp)
 }
 
+func (s *HTTPServer) describeDevice(d *Device, pos int) deviceInfo {
+	info := deviceInfo{
+		ID:           canonicalID(d, pos),
+		Index:        pos,
+		Name:         d.Name,
+		Model:        d.Model,
+		Handle:       d.Handle,
+		IDN:          d.Ident(),
+		State:        d.State(),
+		Capabilities: []string{},
+	}
+	if info.Lock = s.locks.lookup(d); info.Lock != nil {
+		info.Lock.Device = info.ID
+	}
+
+	dev, err := d.driver()
+	if err != nil {
+		return info
+	}
+	if info.Ident, err = dev.GetIdent(); err != nil {
+		s.Logger.LogWarningf("device %s: %s", info.ID, err)
+	}
+	if c, ok := dev.(Capabler); ok {
+		info.Capabilities = c.Capabilities()
+	}
+	if info.Channels, err = dev.GetChannels(); err != nil {
+		s.Logger.LogWarningf("device %s: %s", info.ID, err)
+		return info
+	}
+	if rater, ok := dev.(Rater); ok {
+		for ch := 1; ch <= info.Channels; ch++ {
+			rating, err := rater.GetRating(ch)
+			if err != nil {
+				s.Logger.LogWarningf("device %s: %s", info.ID, err)
+				break
+			}
+			info.Ratings = append(info.Ratings, rating)
+		}
+	}
+	return info
+}
+
+func (s *HTTPServer) getDevicesDetailed(w http.ResponseWriter, r *http.Request) {
+	devices := s.Devices.Devices()
+	resp := make([]deviceInfo, 0, len(devices))
+	for i, d := range devices {
+		if !s.mayAccessDevice(r, strconv.Itoa(i+1)) {
+			continue
+		}
+		resp = append(resp, s.describeDevice(d, i+1))
+	}
+	helpers.SendJSON(w, resp)
+}
+
+func (s *HTTPServer) getChannelsDetailed(w http.ResponseWriter, r *http.Request) {
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	dev, err := d.driver()
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	nChannels, err := dev.GetChannels()
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	resp := make([]channelInfo, 0, nChannels)
+	for ch := 1; ch <= nChannels; ch++ {
+		info := channelInfo{Index: ch}
+		if rater, ok := dev.(Rater); ok {
+			rating, err := rater.GetRating(ch)
+			if err != nil {
+				sendError(w, err)
+				return
+			}
+			info.Rating = &rating
+		}
+		resp = append(resp, info)
+	}
+	helpers.SendJSON(w, resp)
+}
+
+func (s *HTTPServer) getDevice(w http.ResponseWriter, r *http.Request) {
+	d, pos, err := s.Devices.Lookup(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, s.describeDevice(d, pos))
+}
+
 func (s *HTTPServer) getIndent(w http.ResponseWriter, r *http.Request) {
-	dev, err := s.lookupDevice(w, mux.Vars(r))
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return
 	}
 	ident, err := dev.GetIdent()
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, ident)
 }
 
 func (s *HTTPServer) putBeep(w http.ResponseWriter, r *http.Request) {
-	helpers.SendJSONError(w, "not implemented", http.StatusInternalServerError)
+	var req bool
+	dev, err := s.lookupDevice(w, r)
+	if err != nil {
+		return
+	}
+	err = helpers.RecvJSON(r, &req)
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := dev.SetBeep(req); err != nil {
+		sendError(w, err)
+		return
+	}
 }
 
 func (s *HTTPServer) getMaster(w http.ResponseWriter, r *http.Request) {
-	dev, err := s.lookupDevice(w, mux.Vars(r))
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return
 	}
 	state, err := dev.GetMaster()
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, state)
@@ -134,64 +332,58 @@ func (s *HTTPServer) getMaster(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPServer) putMaster(w http.ResponseWriter, r *http.Request) {
 	var req bool
-	dev, err := s.lookupDevice(w, mux.Vars(r))
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return
 	}
 	err = helpers.RecvJSON(r, &req)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if err := dev.SetMaster(req); err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 }
 
 func (s *HTTPServer) getStatus(w http.ResponseWriter, r *http.Request) {
-	dev, err := s.lookupDevice(w, mux.Vars(r))
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return
 	}
 	status, err := dev.Status()
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, status)
 }
 
 func (s *HTTPServer) getChannels(w http.ResponseWriter, r *http.Request) {
-	var (
-		vars = mux.Vars(r)
-	)
-	dev, err := s.lookupDevice(w, vars)
+	dev, err := s.lookupDevice(w, r)
 	if err != nil {
 		return
 	}
 
 	channels, err := dev.GetChannels()
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, channels)
 }
 
 func (s *HTTPServer) getCurrent(w http.ResponseWriter, r *http.Request) {
-	var (
-		vars = mux.Vars(r)
-	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
 
 	current, err := dev.GetCurrent(channel)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, current)
@@ -199,35 +391,31 @@ func (s *HTTPServer) getCurrent(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPServer) putCurrent(w http.ResponseWriter, r *http.Request) {
 	var (
-		req  float64
-		vars = mux.Vars(r)
+		req float64
 	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
 	err = helpers.RecvJSON(r, &req)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if err := dev.SetCurrent(channel, req); err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 }
 
 func (s *HTTPServer) getVoltage(w http.ResponseWriter, r *http.Request) {
-	var (
-		vars = mux.Vars(r)
-	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
 	voltage, err := dev.GetVoltage(channel)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, voltage)
@@ -239,6 +427,28 @@ const (
 	measurementBoth
 )
 
+func measure(dev Netzteil, channel, mtype int) (measurement, error) {
+	var (
+		m   measurement
+		err error
+	)
+	switch mtype {
+	case measurementVoltage:
+		m.Voltage, err = dev.GetVoltage(channel)
+	case measurementCurrent:
+		m.Current, err = dev.GetCurrent(channel)
+	case measurementBoth:
+		if m.Voltage, err = dev.GetVoltage(channel); err != nil {
+			return m, err
+		}
+		m.Current, err = dev.GetCurrent(channel)
+	default:
+		panic("BUG: this invalid measurement type")
+	}
+	m.Time = time.Now()
+	return m, err
+}
+
 func (s *HTTPServer) continousMeasurement(w http.ResponseWriter, r *http.Request, dev Netzteil, channel, mtype, interval int) {
 	upgrader := websocket.Upgrader{}
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -250,44 +460,13 @@ func (s *HTTPServer) continousMeasurement(w http.ResponseWriter, r *http.Request
 	go readLoop(conn)
 
 	for {
-		var (
-			val float64
-			err error
-			m   measurement
-		)
-		switch mtype {
-		case measurementVoltage:
-			val, err = dev.GetVoltage(channel)
-			m.Time = time.Now()
-			m.Voltage = val
-		case measurementCurrent:
-			val, err = dev.GetCurrent(channel)
-			m.Time = time.Now()
-			m.Current = val
-		case measurementBoth:
-			val, err = dev.GetVoltage(channel)
-			if err != nil {
-				m := map[string]string{"error": err.Error()}
-				if err := conn.WriteJSON(m); err != nil {
-					return
-				}
-				continue
-			}
-			m.Time = time.Now()
-			m.Voltage = val
-			val, err = dev.GetCurrent(channel)
-			m.Current = val
-		default:
-			panic("BUG: this invalid measurement type")
-		}
+		m, err := measure(dev, channel, mtype)
 		if err != nil {
 			m := map[string]string{"error": err.Error()}
 			if err := conn.WriteJSON(m); err != nil {
 				return
 			}
-			continue
-		}
-		if err := conn.WriteJSON(m); err != nil {
+		} else if err := conn.WriteJSON(m); err != nil {
 			return
 		}
 		time.Sleep(time.Duration(interval) * time.Millisecond)
@@ -295,10 +474,7 @@ func (s *HTTPServer) continousMeasurement(w http.ResponseWriter, r *http.Request
 }
 
 func (s *HTTPServer) getVoltageWS(w http.ResponseWriter, r *http.Request) {
-	var (
-		vars = mux.Vars(r)
-	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
@@ -313,8 +489,7 @@ func (s *HTTPServer) getVoltageWS(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HTTPServer) getCurrentWS(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
@@ -329,8 +504,7 @@ func (s *HTTPServer) getCurrentWS(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *HTTPServer) getMeasurementsWS(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
@@ -346,42 +520,40 @@ func (s *HTTPServer) getMeasurementsWS(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPServer) putVoltage(w http.ResponseWriter, r *http.Request) {
 	var (
-		req  float64
-		vars = mux.Vars(r)
+		req float64
 	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
 	if err != nil {
 		return
 	}
 	err = helpers.RecvJSON(r, &req)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if err := dev.SetVoltage(channel, req); err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
 		return
 	}
 }
 
 func (s *HTTPServer) getOut(w http.ResponseWriter, r *http.Request) {
 	var (
-		on   bool
-		err  error
-		vars = mux.Vars(r)
+		on  bool
+		err error
 	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseOutChannel(w, r)
 	if err != nil {
 		return
 	}
-	if channel == 0 {
+	if channel == MasterChannel {
 		on, err = dev.GetMaster()
 	} else {
 		on, err = dev.GetOut(channel)
 	}
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendError(w, err)
 		return
 	}
 	helpers.SendJSON(w, on)
@@ -389,45 +561,89 @@ func (s *HTTPServer) getOut(w http.ResponseWriter, r *http.Request) {
 
 func (s *HTTPServer) putOut(w http.ResponseWriter, r *http.Request) {
 	var (
-		req  bool
-		vars = mux.Vars(r)
+		req bool
 	)
-	dev, channel, err := s.lookupDevAndParseChannel(w, vars)
+	dev, channel, err := s.lookupDevAndParseOutChannel(w, r)
 	if err != nil {
 		return
 	}
 
 	err = helpers.RecvJSON(r, &req)
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusBadRequest)
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if channel == 0 {
+	if channel == MasterChannel {
 		err = dev.SetMaster(req)
 	} else {
 		err = dev.SetOut(channel, req)
 	}
 	if err != nil {
-		helpers.SendJSONError(w, err.Error(), http.StatusInternalServerError)
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) getProtection(w http.ResponseWriter, r *http.Request, get func(Netzteil, int) (bool, error)) {
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
+	if err != nil {
+		return
+	}
+	on, err := get(dev, channel)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, on)
+}
+
+func (s *HTTPServer) putProtection(w http.ResponseWriter, r *http.Request, set func(Netzteil, int, bool) error) {
+	var req bool
+	dev, channel, err := s.lookupDevAndParseChannel(w, r)
+	if err != nil {
+		return
+	}
+	err = helpers.RecvJSON(r, &req)
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := set(dev, channel, req); err != nil {
+		sendError(w, err)
 		return
 	}
 }
 
 func (s *HTTPServer) getOcp(w http.ResponseWriter, r *http.Request) {
-	helpers.SendJSONError(w, "not implemented", http.StatusInternalServerError)
+	s.getProtection(w, r, Netzteil.GetOCP)
 }
 
 func (s *HTTPServer) putOcp(w http.ResponseWriter, r *http.Request) {
-	helpers.SendJSONError(w, "not implemented", http.StatusInternalServerError)
+	s.putProtection(w, r, Netzteil.SetOCP)
 }
 
 func (s *HTTPServer) getOvp(w http.ResponseWriter, r *http.Request) {
-	helpers.SendJSONError(w, "not implemented", http.StatusInternalServerError)
+	s.getProtection(w, r, Netzteil.GetOVP)
 }
 
 func (s *HTTPServer) putOvp(w http.ResponseWriter, r *http.Request) {
-	helpers.SendJSONError(w, "not implemented", http.StatusInternalServerError)
+	s.putProtection(w, r, Netzteil.SetOVP)
+}
+
+func (s *HTTPServer) postReload(w http.ResponseWriter, r *http.Request) {
+	if p := principalFromContext(r.Context()); p != nil && p.Permission < PermissionAdmin {
+		sendErrorStatus(w, fmt.Sprintf("'%s' has no admin access", p.Name), http.StatusForbidden)
+		return
+	}
+	if s.Reload == nil {
+		sendErrorStatus(w, "reload not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		sendError(w, err)
+		return
+	}
 }
 
 // Magic handler for reduced API
@@ -441,48 +657,117 @@ func (s *HTTPServer) redAPI(w http.ResponseWriter, r *http.Request) {
 		path      = ""
 	)
 	if strings.HasPrefix(u.Path, devPrefix) {
+		if s.Devices.Len() != 1 {
+			sendErrorStatus(w, "reduced API requires exactly one device", http.StatusNotFound)
+			return
+		}
 		pathSuffix := strings.TrimPrefix(u.Path, devPrefix)
-		path = fmt.Sprintf("/_netzteil/api/devices/0/%s", pathSuffix)
+		path = fmt.Sprintf("/_netzteil/api/devices/1/%s", pathSuffix)
 	} else if strings.HasPrefix(u.Path, chPrefix) {
 		pathSuffix := strings.TrimPrefix(u.Path, chPrefix)
-		path = fmt.Sprintf("/_netzteil/api/devices/%s/channels/0/%s", id, pathSuffix)
+		path = fmt.Sprintf("/_netzteil/api/devices/%s/channels/1/%s", id, pathSuffix)
 	} else {
-		helpers.SendJSONError(w, "wrong prefix", http.StatusNotFound)
+		sendErrorStatus(w, "wrong prefix", http.StatusNotFound)
+		return
 	}
 	http.Redirect(w, r, path, http.StatusPermanentRedirect)
 }
 
+// addRoutes registers the endpoints which are shared
+// by all API versions.
+func (s *HTTPServer) addRoutes(api *mux.Router) {
+	api.HandleFunc("/locks", s.getLocks).Methods(http.MethodGet)
+	api.HandleFunc("/audit", s.getAudit).Methods(http.MethodGet)
+	api.HandleFunc("/admin/reload", s.postReload).Methods(http.MethodPost)
+	api.HandleFunc("/leases", s.getLeases).Methods(http.MethodGet)
+	api.HandleFunc("/leases/{lease}", s.putLease).Methods(http.MethodPut)
+	api.HandleFunc("/leases/{lease}", s.deleteLease).Methods(http.MethodDelete)
+	api.HandleFunc("/loads", s.getLoads).Methods(http.MethodGet)
+	api.HandleFunc("/loads/{load}", s.getLoad).Methods(http.MethodGet)
+	api.HandleFunc("/loads/{load}/mode", s.getLoadMode).Methods(http.MethodGet)
+	api.HandleFunc("/loads/{load}/mode", s.putLoadMode).Methods(http.MethodPut)
+	api.HandleFunc("/loads/{load}/setpoints/{mode}", s.getLoadSetpoint).Methods(http.MethodGet)
+	api.HandleFunc("/loads/{load}/setpoints/{mode}", s.putLoadSetpoint).Methods(http.MethodPut)
+	api.HandleFunc("/loads/{load}/input", s.getLoadInput).Methods(http.MethodGet)
+	api.HandleFunc("/loads/{load}/input", s.putLoadInput).Methods(http.MethodPut)
+	api.HandleFunc("/loads/{load}/measurements", s.getLoadMeasurements).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/ident", s.getIndent).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/beep", s.putBeep).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/out", s.getMaster).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/out", s.putMaster).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/health", s.getHealth).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/events", s.getEvents).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/lease", s.postMasterLease).Methods(http.MethodPost)
+	api.HandleFunc("/devices/{id}/presets", s.getPresets).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/presets/{preset:[0-9]+}/save", s.postPreset(true)).Methods(http.MethodPost)
+	api.HandleFunc("/devices/{id}/presets/{preset:[0-9]+}/recall", s.postPreset(false)).Methods(http.MethodPost)
+	api.HandleFunc("/devices/{id}/tracking", s.putTracking).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/panel-lock", s.putPanelLock).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/lock", s.getLock).Methods(http.MethodGet).Name("lock")
+	api.HandleFunc("/devices/{id}/lock", s.postLock).Methods(http.MethodPost).Name("lock")
+	api.HandleFunc("/devices/{id}/lock", s.deleteLock).Methods(http.MethodDelete).Name("lock")
+	api.HandleFunc("/devices/{id}/status", s.getStatus).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/state", s.getDeviceState).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels", s.getChannels).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels", s.putChannels).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}", s.putChannel).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/state", s.getChannelState).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/current", s.getCurrent).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/current", s.putCurrent).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/current/ws", s.getCurrentWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/voltage", s.getVoltage).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/voltage", s.putVoltage).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/voltage/ws", s.getVoltageWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/measurements/ws", s.getMeasurementsWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/current/sse", s.getMeasurementsSSE(measurementCurrent)).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/voltage/sse", s.getMeasurementsSSE(measurementVoltage)).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/measurements/sse", s.getMeasurementsSSE(measurementBoth)).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/out", s.getOut).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/out", s.putOut).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/lease", s.postLease).Methods(http.MethodPost)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/ocp", s.getOcp).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/ocp", s.putOcp).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/ovp", s.getOvp).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/ovp", s.putOvp).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/timer", s.putTimer).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/timer/steps", s.putTimerSteps).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/fuse/delay", s.getFuseDelay).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/fuse/delay", s.putFuseDelay).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/fuse/links/{other:[0-9]+}", s.fuseLink(true)).Methods(http.MethodPut)
+	api.HandleFunc("/devices/{id}/channels/{channel:[0-9]+}/fuse/links/{other:[0-9]+}", s.fuseLink(false)).Methods(http.MethodDelete)
+}
+
 func (s *HTTPServer) CreateHandler() http.Handler {
+	s.leases = newLeaseManager(s.Logger)
+	s.locks = newLockManager()
+	if s.Loads == nil {
+		s.Loads = NewLoadRegistry(s.Logger)
+	}
+
 	r := mux.NewRouter()
 	api := r.PathPrefix("/_netzteil/api").Subrouter()
+	api.Use(s.authMiddleware)
+	api.Use(s.lockMiddleware)
+	api.HandleFunc("/openapi.json", s.getOpenAPI).Methods(http.MethodGet)
+
+	// Version 2 of the API uses JSON objects where the plain
+	// API uses scalars or lists of strings.
+	v2 := api.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/devices", s.getDevicesDetailed).Methods(http.MethodGet)
+	v2.HandleFunc("/devices/{id}", s.getDevice).Methods(http.MethodGet)
+	v2.HandleFunc("/devices/{id}/channels", s.getChannelsDetailed).Methods(http.MethodGet)
+	s.addRoutes(v2)
+
 	api.HandleFunc("/devices", s.getDevices).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/ident", s.getIndent).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/beep", s.putBeep).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/out", s.getMaster).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/out", s.putMaster).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/status", s.getStatus).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels", s.getChannels).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/current", s.getCurrent).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/current", s.putCurrent).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/current/ws", s.getCurrentWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/voltage", s.getVoltage).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/voltage", s.putVoltage).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/voltage/ws", s.getVoltageWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/measurements/ws", s.getMeasurementsWS).Methods(http.MethodGet).Queries("interval", "{interval:[0-9]+}")
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/out", s.getOut).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/out", s.putOut).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/ocp", s.getOcp).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/ocp", s.putOcp).Methods(http.MethodPut)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/ovp", s.getOvp).Methods(http.MethodGet)
-	api.HandleFunc("/devices/{id:[0-9]+}/channels/{channel:[0-9]+}/ovp", s.putOvp).Methods(http.MethodPut)
-	chPrefix := api.PathPrefix("/devices/{id:[0-9]+}/channel/")
+	s.addRoutes(api)
+	chPrefix := api.PathPrefix("/devices/{id}/channel/")
 	chPrefix.HandlerFunc(s.redAPI).Methods(http.MethodGet, http.MethodPut)
 
-	// Enable reduced API if only one powersupply device is registered.
-	if len(s.Devices) == 1 {
-		deviceChPrefix := api.PathPrefix("/device/")
-		deviceChPrefix.HandlerFunc(s.redAPI).Methods(http.MethodGet, http.MethodPut)
-	}
+	// The reduced API is only available if exactly one powersupply
+	// device is registered. Since the device list can be reloaded
+	// at runtime, this is checked in the handler.
+	deviceChPrefix := api.PathPrefix("/device/")
+	deviceChPrefix.HandlerFunc(s.redAPI).Methods(http.MethodGet, http.MethodPut)
 
 	return handlers.LoggingHandler(s.ReqLog, r)
 }
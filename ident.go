@@ -0,0 +1,34 @@
+package opennetzteil
+
+import "strings"
+
+// Ident is the parsed response of the SCPI *IDN? command:
+// "<manufacturer>,<model>,<serial>,<firmware>".
+type Ident struct {
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	Serial       string `json:"serial"`
+	Firmware     string `json:"firmware"`
+}
+
+// ParseIdent parses an *IDN? response. Devices which do not follow
+// the SCPI format, e.g. "RND 320-KD3005P V2.0", are returned as model.
+func ParseIdent(ident string) Ident {
+	fields := strings.Split(strings.TrimSpace(ident), ",")
+	if len(fields) < 2 {
+		return Ident{Model: strings.TrimSpace(ident)}
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	var res Ident
+	res.Manufacturer = fields[0]
+	res.Model = fields[1]
+	if len(fields) > 2 {
+		res.Serial = fields[2]
+	}
+	if len(fields) > 3 {
+		res.Firmware = strings.Join(fields[3:], ",")
+	}
+	return res
+}
@@ -0,0 +1,591 @@
+package opennetzteil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// Regulation modes of electronic loads, in addition to ModeCC and ModeCV.
+const (
+	ModeCR = "CR"
+	ModeCP = "CP"
+)
+
+// LoadModes are the regulation modes of the Last interface.
+var LoadModes = []string{ModeCC, ModeCV, ModeCR, ModeCP}
+
+// CheckLoadMode returns ErrOutOfRange if mode is not one of LoadModes.
+func CheckLoadMode(mode string) error {
+	for _, m := range LoadModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: mode '%s'; must be one of %s", ErrOutOfRange, mode, strings.Join(LoadModes, ", "))
+}
+
+// Last is implemented by drivers of electronic loads. Loads have a
+// single input. Each mode has its own setpoint: the current in A for
+// ModeCC, the voltage in V for ModeCV, the resistance in Ω for ModeCR,
+// and the power in W for ModeCP.
+type Last interface {
+	Probe() error
+	GetIdent() (string, error)
+	GetMode() (string, error)
+	SetMode(mode string) error
+	GetSetpoint(mode string) (float64, error)
+	SetSetpoint(mode string, value float64) error
+	GetInput() (bool, error)
+	SetInput(enabled bool) error
+	GetVoltage() (float64, error)
+	GetCurrent() (float64, error)
+	GetPower() (float64, error)
+}
+
+// Load is an electronic load managed by a LoadRegistry. Unlike
+// devices, loads are not supervised; the driver is opened on demand
+// and closed after transport errors. After a failed connect, requests
+// fail without connecting until the retry interval elapsed; the
+// interval doubles with each failure.
+type Load struct {
+	// Key identifies the load configuration. Loads with
+	// the same key are considered equal on LoadRegistry.Update().
+	Key    string
+	Name   string
+	Model  string
+	Handle string
+	Open   func() (Last, error)
+
+	mutex    sync.Mutex
+	last     Last
+	ident    Ident
+	rawIdent string
+	err      error
+	auditLog *AuditLog
+
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	backoff          time.Duration
+	retry            time.Time
+}
+
+type loadMeasurement struct {
+	Voltage float64   `json:"voltage"`
+	Current float64   `json:"current"`
+	Power   float64   `json:"power"`
+	Time    time.Time `json:"time"`
+}
+
+type loadInfo struct {
+	ID     string      `json:"id"`
+	Index  int         `json:"index"`
+	Name   string      `json:"name"`
+	Model  string      `json:"model"`
+	Handle string      `json:"handle"`
+	Ident  string      `json:"ident"`
+	IDN    Ident       `json:"idn"`
+	State  DeviceState `json:"state"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func closeLast(last Last) {
+	if c, ok := last.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// connect must be called with l.mutex held.
+func (l *Load) connect() error {
+	if l.last != nil {
+		return nil
+	}
+	if time.Now().Before(l.retry) {
+		return fmt.Errorf("%w: %s", ErrDeviceOffline, l.err)
+	}
+	last, err := l.Open()
+	if err == nil {
+		if err = last.Probe(); err != nil {
+			closeLast(last)
+			err = fmt.Errorf("probe failed: %w", err)
+		}
+	}
+	if err != nil {
+		l.err = err
+		l.retryLater()
+		return fmt.Errorf("%w: %s", ErrDeviceOffline, err)
+	}
+	l.last = last
+	l.err = nil
+	l.backoff = 0
+	l.retry = time.Time{}
+	if r, ok := last.(interface{ RawIdent() string }); ok {
+		l.rawIdent = r.RawIdent()
+	} else if ident, err := last.GetIdent(); err == nil {
+		l.rawIdent = ident
+	}
+	l.ident = ParseIdent(l.rawIdent)
+	return nil
+}
+
+// retryLater doubles the retry interval, starting with
+// retryInterval. It must be called with l.mutex held.
+func (l *Load) retryLater() {
+	switch {
+	case l.backoff == 0:
+		l.backoff = l.retryInterval
+	case l.backoff < l.maxRetryInterval:
+		l.backoff *= 2
+		if l.backoff > l.maxRetryInterval {
+			l.backoff = l.maxRetryInterval
+		}
+	}
+	l.retry = time.Now().Add(l.backoff)
+}
+
+// cachedIdent returns the identification of the load if it is
+// connected and an empty string otherwise. It does not connect.
+func (l *Load) cachedIdent() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.last == nil {
+		return ""
+	}
+	return l.rawIdent
+}
+
+// do runs f with exclusive access to the driver.
+func (l *Load) do(f func(last Last) error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.connect(); err != nil {
+		return err
+	}
+	err := f(l.last)
+	if errors.Is(err, ErrTransport) || errors.Is(err, ErrTimeout) {
+		closeLast(l.last)
+		l.last = nil
+		l.err = err
+	}
+	return err
+}
+
+func (l *Load) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.last != nil {
+		closeLast(l.last)
+		l.last = nil
+	}
+}
+
+// ID returns the configured name or, if
+// unset, the serial number of the load.
+func (l *Load) ID() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.ident.Serial
+}
+
+func (l *Load) label() string {
+	if id := l.ID(); id != "" {
+		return id
+	}
+	return l.Key
+}
+
+func (l *Load) audit(a actor, cmd string, new interface{}, err error) {
+	if l.auditLog == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:    time.Now(),
+		Client:  a.Name,
+		Address: a.Address,
+		Device:  l.label(),
+		Command: cmd,
+		New:     new,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	l.auditLog.Record(e)
+}
+
+// LoadRegistry is the list of electronic loads served by the HTTPServer.
+type LoadRegistry struct {
+	Logger *penlogger.Logger
+	// Audit records all state-changing commands if set.
+	Audit *AuditLog
+	// RetryInterval is the initial reconnect interval of offline
+	// loads. It is doubled on each failed attempt up to
+	// MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	mutex sync.RWMutex
+	loads []*Load
+}
+
+func NewLoadRegistry(logger *penlogger.Logger) *LoadRegistry {
+	return &LoadRegistry{
+		Logger:           logger,
+		RetryInterval:    5 * time.Second,
+		MaxRetryInterval: 5 * time.Minute,
+	}
+}
+
+// Loads returns a snapshot of the load list.
+func (r *LoadRegistry) Loads() []*Load {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	loads := make([]*Load, len(r.loads))
+	copy(loads, r.loads)
+	return loads
+}
+
+// Lookup resolves a load id like Registry.Lookup().
+func (r *LoadRegistry) Lookup(id string) (*Load, int, error) {
+	loads := r.Loads()
+	if n, err := strconv.Atoi(id); err == nil {
+		if n < 1 || n > len(loads) {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+		}
+		return loads[n-1], n, nil
+	}
+	for i, l := range loads {
+		if l.Name == id {
+			return l, i + 1, nil
+		}
+	}
+	for i, l := range loads {
+		if l.ID() == id {
+			return l, i + 1, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+}
+
+// CheckLoads is like CheckDevices for loads.
+func CheckLoads(loads []*Load) error {
+	var (
+		keys  = make(map[string]bool)
+		names = make(map[string]bool)
+	)
+	for _, l := range loads {
+		if keys[l.Key] {
+			return fmt.Errorf("duplicate load: %s", l.Key)
+		}
+		keys[l.Key] = true
+		if l.Name == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(l.Name); err == nil {
+			return fmt.Errorf("invalid load name: %s; numbers are reserved for positions", l.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate load name: %s", l.Name)
+		}
+		names[l.Name] = true
+	}
+	return nil
+}
+
+// Update replaces the load list with loads. Loads with a key already
+// present in the registry are kept untouched, removed loads are closed,
+// and new loads are connected. If loads fails CheckLoads(), the
+// registry is left unchanged.
+func (r *LoadRegistry) Update(loads []*Load) error {
+	var (
+		added []*Load
+		list  []*Load
+	)
+
+	if err := CheckLoads(loads); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	old := make(map[string]*Load)
+	for _, l := range r.loads {
+		old[l.Key] = l
+	}
+	for _, l := range loads {
+		if o, ok := old[l.Key]; ok {
+			list = append(list, o)
+			delete(old, l.Key)
+			continue
+		}
+		l.auditLog = r.Audit
+		l.retryInterval = r.RetryInterval
+		l.maxRetryInterval = r.MaxRetryInterval
+		list = append(list, l)
+		added = append(added, l)
+	}
+	r.loads = list
+	r.mutex.Unlock()
+
+	for _, l := range old {
+		r.Logger.LogInfof("load %s removed", l.Key)
+		l.close()
+	}
+	for _, l := range added {
+		err := l.do(func(last Last) error { return nil })
+		if err != nil {
+			r.Logger.LogWarningf("load %s is offline: %s", l.Key, err)
+		} else {
+			r.Logger.LogInfof("load %s added", l.Key)
+		}
+	}
+	return nil
+}
+
+// Close closes all loads.
+func (r *LoadRegistry) Close() {
+	r.Update(nil)
+}
+
+// loadAliases returns all ids which refer to the same load as id.
+func (s *HTTPServer) loadAliases(id string) []string {
+	l, pos, err := s.Loads.Lookup(id)
+	if err != nil {
+		return []string{id}
+	}
+	return []string{id, strconv.Itoa(pos), l.Name, l.ID()}
+}
+
+// mayAccessLoad is like mayAccessDevice for loads.
+func (s *HTTPServer) mayAccessLoad(r *http.Request, id string) bool {
+	p := principalFromContext(r.Context())
+	return p == nil || p.mayAccess(s.loadAliases(id)...)
+}
+
+func (s *HTTPServer) lookupLoad(w http.ResponseWriter, r *http.Request) (*Load, error) {
+	l, _, err := s.Loads.Lookup(mux.Vars(r)["load"])
+	if err != nil {
+		sendError(w, err)
+		return nil, err
+	}
+	return l, nil
+}
+
+func parseLoadMode(mode string) (string, error) {
+	mode = strings.ToUpper(mode)
+	if err := CheckLoadMode(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+func (s *HTTPServer) getLoads(w http.ResponseWriter, r *http.Request) {
+	resp := []string{}
+	for i, l := range s.Loads.Loads() {
+		// Keep offline and inaccessible loads in the list;
+		// otherwise the positional ids would be shifted.
+		if !s.mayAccessLoad(r, strconv.Itoa(i+1)) {
+			resp = append(resp, "")
+			continue
+		}
+		// Do not connect; each offline load
+		// would delay the response.
+		resp = append(resp, l.cachedIdent())
+	}
+	helpers.SendJSON(w, resp)
+}
+
+func (s *HTTPServer) getLoad(w http.ResponseWriter, r *http.Request) {
+	l, pos, err := s.Loads.Lookup(mux.Vars(r)["load"])
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	info := loadInfo{
+		Index:  pos,
+		Name:   l.Name,
+		Model:  l.Model,
+		Handle: l.Handle,
+		State:  StateOnline,
+	}
+	err = l.do(func(last Last) error {
+		info.Ident, err = last.GetIdent()
+		return err
+	})
+	if err != nil {
+		info.State = StateOffline
+		info.Error = err.Error()
+	}
+	l.mutex.Lock()
+	info.IDN = l.ident
+	l.mutex.Unlock()
+	if info.ID = l.ID(); info.ID == "" {
+		info.ID = strconv.Itoa(pos)
+	}
+	helpers.SendJSON(w, info)
+}
+
+func (s *HTTPServer) getLoadMode(w http.ResponseWriter, r *http.Request) {
+	var mode string
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	err = l.do(func(last Last) error {
+		mode, err = last.GetMode()
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, mode)
+}
+
+func (s *HTTPServer) putLoadMode(w http.ResponseWriter, r *http.Request) {
+	var req string
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := parseLoadMode(req)
+	if err == nil {
+		err = l.do(func(last Last) error {
+			return last.SetMode(mode)
+		})
+	}
+	l.audit(requestActor(r), "SetMode", req, err)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) getLoadSetpoint(w http.ResponseWriter, r *http.Request) {
+	var val float64
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	mode, err := parseLoadMode(mux.Vars(r)["mode"])
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	err = l.do(func(last Last) error {
+		val, err = last.GetSetpoint(mode)
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, val)
+}
+
+func (s *HTTPServer) putLoadSetpoint(w http.ResponseWriter, r *http.Request) {
+	var req float64
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	mode, err := parseLoadMode(mux.Vars(r)["mode"])
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = l.do(func(last Last) error {
+		return last.SetSetpoint(mode, req)
+	})
+	l.audit(requestActor(r), "SetSetpoint"+mode, req, err)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) getLoadInput(w http.ResponseWriter, r *http.Request) {
+	var on bool
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	err = l.do(func(last Last) error {
+		on, err = last.GetInput()
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, on)
+}
+
+func (s *HTTPServer) putLoadInput(w http.ResponseWriter, r *http.Request) {
+	var req bool
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = l.do(func(last Last) error {
+		return last.SetInput(req)
+	})
+	l.audit(requestActor(r), "SetInput", req, err)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) getLoadMeasurements(w http.ResponseWriter, r *http.Request) {
+	var m loadMeasurement
+	l, err := s.lookupLoad(w, r)
+	if err != nil {
+		return
+	}
+	err = l.do(func(last Last) error {
+		var err error
+		if m.Voltage, err = last.GetVoltage(); err != nil {
+			return err
+		}
+		if m.Current, err = last.GetCurrent(); err != nil {
+			return err
+		}
+		if m.Power, err = last.GetPower(); err != nil {
+			return err
+		}
+		m.Time = time.Now()
+		return nil
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, m)
+}
@@ -0,0 +1,202 @@
+package opennetzteil_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/rumpelsepp/opennetzteil"
+	"github.com/rumpelsepp/opennetzteil/devices/dummy"
+)
+
+// offlineLoad returns an Open function of a load which
+// is never reachable and counts the connection attempts.
+func offlineLoad() (func() (opennetzteil.Last, error), func() int) {
+	var (
+		mutex sync.Mutex
+		n     int
+	)
+	open := func() (opennetzteil.Last, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		n++
+		return nil, errors.New("connection refused")
+	}
+	attempts := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return n
+	}
+	return open, attempts
+}
+
+func newLoadServer(t *testing.T, retry, maxRetry time.Duration, loads []*opennetzteil.Load) *httptest.Server {
+	logger := penlogger.NewLogger("test", io.Discard)
+	registry := opennetzteil.NewLoadRegistry(logger)
+	registry.RetryInterval = retry
+	registry.MaxRetryInterval = maxRetry
+	registry.Update(loads)
+	t.Cleanup(registry.Close)
+
+	devices := opennetzteil.NewRegistry(logger)
+	t.Cleanup(devices.Close)
+
+	api := opennetzteil.HTTPServer{
+		ReqLog:  io.Discard,
+		Logger:  logger,
+		Devices: devices,
+		Loads:   registry,
+	}
+	srv := httptest.NewServer(api.CreateHandler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// loadRequest sends a request below /loads and decodes the response
+// into resp if the request succeeded; it returns the status and the
+// error code.
+func loadRequest(t *testing.T, srv *httptest.Server, method, path, body string, resp interface{}) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+"/_netzteil/api/loads"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 400 {
+		var apiErr struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(r.Body).Decode(&apiErr)
+		return r.StatusCode, apiErr.Code
+	}
+	if resp != nil {
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+	return r.StatusCode, ""
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	open, attempts := offlineLoad()
+	srv := newLoadServer(t, time.Hour, time.Hour, []*opennetzteil.Load{
+		{
+			Key:   "dummy",
+			Name:  "dummy",
+			Model: "dummy",
+			Open:  func() (opennetzteil.Last, error) { return dummy.NewDummyLoad("dummy"), nil },
+		},
+		{Key: "offline", Model: "dl3000", Open: open},
+	})
+
+	var idents []string
+	if status, code := loadRequest(t, srv, http.MethodGet, "", "", &idents); status != http.StatusOK {
+		t.Fatalf("GET /loads: %d %s", status, code)
+	}
+	if len(idents) != 2 || idents[0] != "opennetzteil,DummyLoad,0,0" || idents[1] != "" {
+		t.Errorf("GET /loads: got %q", idents)
+	}
+
+	var info struct {
+		ID    string             `json:"id"`
+		IDN   opennetzteil.Ident `json:"idn"`
+		State string             `json:"state"`
+	}
+	loadRequest(t, srv, http.MethodGet, "/dummy", "", &info)
+	if info.ID != "dummy" || info.IDN.Model != "DummyLoad" || info.State != string(opennetzteil.StateOnline) {
+		t.Errorf("GET /loads/dummy: got %+v", info)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{http.MethodPut, "/1/mode", `"cr"`, http.StatusOK, ""},
+		{http.MethodPut, "/1/mode", `"cx"`, http.StatusUnprocessableEntity, "out_of_range"},
+		{http.MethodPut, "/1/setpoints/cr", "5.5", http.StatusOK, ""},
+		{http.MethodPut, "/1/setpoints/cc", "-1", http.StatusUnprocessableEntity, "out_of_range"},
+		{http.MethodGet, "/1/setpoints/cx", "", http.StatusUnprocessableEntity, "out_of_range"},
+		{http.MethodPut, "/1/input", "true", http.StatusOK, ""},
+		{http.MethodPut, "/1/input", "on", http.StatusBadRequest, ""},
+		{http.MethodGet, "/3/mode", "", http.StatusNotFound, "device_not_found"},
+		{http.MethodGet, "/2/mode", "", http.StatusServiceUnavailable, "device_offline"},
+		{http.MethodPut, "/2/input", "false", http.StatusServiceUnavailable, "device_offline"},
+	}
+	for _, tc := range tests {
+		status, code := loadRequest(t, srv, tc.method, tc.path, tc.body, nil)
+		if status != tc.status || (tc.code != "" && code != tc.code) {
+			t.Errorf("%s %s: got %d %s, want %d %s", tc.method, tc.path, status, code, tc.status, tc.code)
+		}
+	}
+
+	var mode string
+	loadRequest(t, srv, http.MethodGet, "/1/mode", "", &mode)
+	if mode != opennetzteil.ModeCR {
+		t.Errorf("got mode %q, want CR", mode)
+	}
+	var setpoint float64
+	loadRequest(t, srv, http.MethodGet, "/1/setpoints/CR", "", &setpoint)
+	if setpoint != 5.5 {
+		t.Errorf("got setpoint %g, want 5.5", setpoint)
+	}
+	var input bool
+	loadRequest(t, srv, http.MethodGet, "/1/input", "", &input)
+	if !input {
+		t.Error("input is off")
+	}
+	var m struct {
+		Voltage float64 `json:"voltage"`
+		Current float64 `json:"current"`
+		Power   float64 `json:"power"`
+	}
+	loadRequest(t, srv, http.MethodGet, "/1/measurements", "", &m)
+	if math.Abs(m.Voltage-11) > 1e-9 || math.Abs(m.Current-2) > 1e-9 || math.Abs(m.Power-22) > 1e-9 {
+		t.Errorf("got %g V, %g A, %g W, want 11 V, 2 A, 22 W", m.Voltage, m.Current, m.Power)
+	}
+
+	// The offline load was only tried once, when it was added.
+	if n := attempts(); n != 1 {
+		t.Errorf("got %d connection attempts, want 1", n)
+	}
+}
+
+func TestLoadBackoff(t *testing.T) {
+	const retry = 100 * time.Millisecond
+	open, attempts := offlineLoad()
+	srv := newLoadServer(t, retry, time.Second, []*opennetzteil.Load{{Key: "offline", Open: open}})
+
+	steps := []struct {
+		wait     time.Duration
+		attempts int
+	}{
+		{0, 1},
+		// The first retry interval elapsed.
+		{retry + 20*time.Millisecond, 2},
+		// The retry interval was doubled.
+		{retry + 20*time.Millisecond, 2},
+		{retry, 3},
+	}
+	for i, step := range steps {
+		time.Sleep(step.wait)
+		loadRequest(t, srv, http.MethodGet, "/1/mode", "", nil)
+		loadRequest(t, srv, http.MethodGet, "", "", nil)
+		if n := attempts(); n != step.attempts {
+			t.Errorf("step %d: got %d connection attempts, want %d", i, n, step.attempts)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package opennetzteil
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// A lease is a dead-man switch for a device or a single channel.
+// The client must renew it before the timeout elapses. Otherwise
+// the output is switched off. Channel 0 refers to the master output.
+type lease struct {
+	ID      string    `json:"id"`
+	Device  string    `json:"device"`
+	Channel int       `json:"channel"`
+	Timeout int64     `json:"timeout"`
+	Expires time.Time `json:"expires"`
+
+	dev   *Device
+	timer *time.Timer
+}
+
+type leaseManager struct {
+	mutex  sync.Mutex
+	leases map[string]*lease
+	logger *penlogger.Logger
+}
+
+func newLeaseManager(logger *penlogger.Logger) *leaseManager {
+	return &leaseManager{
+		leases: make(map[string]*lease),
+		logger: logger,
+	}
+}
+
+func (m *leaseManager) acquire(dev *Device, device string, channel int, timeout time.Duration) (lease, error) {
+	if timeout <= 0 {
+		return lease{}, fmt.Errorf("invalid lease timeout: %s", timeout)
+	}
+	id, err := helpers.RandomStringURLSafe(12)
+	if err != nil {
+		return lease{}, err
+	}
+	l := &lease{
+		ID:      id,
+		Device:  device,
+		Channel: channel,
+		Timeout: timeout.Milliseconds(),
+		Expires: time.Now().Add(timeout),
+		dev:     dev,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l.timer = time.AfterFunc(timeout, func() { m.expire(id) })
+	m.leases[id] = l
+	return *l, nil
+}
+
+func (m *leaseManager) renew(id string) (lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, ok := m.leases[id]
+	if !ok {
+		return lease{}, fmt.Errorf("no such lease '%s'", id)
+	}
+	timeout := time.Duration(l.Timeout) * time.Millisecond
+	l.Expires = time.Now().Add(timeout)
+	l.timer.Reset(timeout)
+	return *l, nil
+}
+
+func (m *leaseManager) release(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, ok := m.leases[id]
+	if !ok {
+		return fmt.Errorf("no such lease '%s'", id)
+	}
+	l.timer.Stop()
+	delete(m.leases, id)
+	return nil
+}
+
+func (m *leaseManager) lookup(id string) (lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, ok := m.leases[id]
+	if !ok {
+		return lease{}, fmt.Errorf("no such lease '%s'", id)
+	}
+	return *l, nil
+}
+
+func (m *leaseManager) list() []lease {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resp := make([]lease, 0, len(m.leases))
+	for _, l := range m.leases {
+		resp = append(resp, *l)
+	}
+	return resp
+}
+
+func (m *leaseManager) expire(id string) {
+	m.mutex.Lock()
+	l, ok := m.leases[id]
+	// The lease might have been renewed while this callback
+	// was waiting for the mutex.
+	if !ok || time.Now().Before(l.Expires) {
+		m.mutex.Unlock()
+		return
+	}
+	delete(m.leases, id)
+	m.mutex.Unlock()
+
+	dev, err := l.dev.netzteilAs(actor{Name: "lease " + l.ID})
+	if err == nil {
+		if l.Channel == MasterChannel {
+			err = dev.SetMaster(false)
+		} else {
+			err = dev.SetOut(l.Channel, false)
+		}
+	}
+	if err != nil {
+		l.dev.deferSwitchOff(actor{Name: "lease " + l.ID}, l.Channel)
+		m.logger.LogErrorf("lease %s expired; switching off device %s channel %d failed, retrying once the device is reachable: %s", l.ID, l.Device, l.Channel, err)
+		return
+	}
+	m.logger.LogWarningf("lease %s expired; switched off device %s channel %d", l.ID, l.Device, l.Channel)
+}
+
+func (s *HTTPServer) acquireLease(w http.ResponseWriter, r *http.Request, dev *Device, id string, channel int) {
+	var req int64
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l, err := s.leases.acquire(dev, id, channel, time.Duration(req)*time.Millisecond)
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Logger.LogInfof("lease %s acquired for device %s channel %d", l.ID, l.Device, l.Channel)
+	helpers.SendJSON(w, l)
+}
+
+func (s *HTTPServer) postMasterLease(w http.ResponseWriter, r *http.Request) {
+	dev, id, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	s.acquireLease(w, r, dev, id, 0)
+}
+
+func (s *HTTPServer) postLease(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dev, id, err := s.lookupDeviceEntry(w, vars)
+	if err != nil {
+		return
+	}
+	_, channel, err := s.lookupDevAndParseOutChannel(w, r)
+	if err != nil {
+		return
+	}
+	s.acquireLease(w, r, dev, id, channel)
+}
+
+func (s *HTTPServer) getLeases(w http.ResponseWriter, r *http.Request) {
+	resp := []lease{}
+	for _, l := range s.leases.list() {
+		if s.mayAccessDevice(r, l.Device) {
+			resp = append(resp, l)
+		}
+	}
+	helpers.SendJSON(w, resp)
+}
+
+// checkLeaseAccess rejects requests to leases of devices
+// the client has no access to.
+func (s *HTTPServer) checkLeaseAccess(w http.ResponseWriter, r *http.Request) error {
+	l, err := s.leases.lookup(mux.Vars(r)["lease"])
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusNotFound)
+		return err
+	}
+	if !s.mayAccessDevice(r, l.Device) {
+		p := principalFromContext(r.Context())
+		err := fmt.Errorf("'%s' has no access to device '%s'", p.Name, l.Device)
+		sendErrorStatus(w, err.Error(), http.StatusForbidden)
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPServer) putLease(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkLeaseAccess(w, r); err != nil {
+		return
+	}
+	l, err := s.leases.renew(mux.Vars(r)["lease"])
+	if err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	helpers.SendJSON(w, l)
+}
+
+func (s *HTTPServer) deleteLease(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkLeaseAccess(w, r); err != nil {
+		return
+	}
+	if err := s.leases.release(mux.Vars(r)["lease"]); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusNotFound)
+		return
+	}
+}
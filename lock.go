@@ -0,0 +1,223 @@
+package opennetzteil
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// OwnerHeader identifies the client issuing a request if authentication
+// is disabled. Mutating requests to a locked device are only accepted
+// from the lock owner.
+const OwnerHeader = "Netzteil-Owner"
+
+// A lock is held for the Device itself; Device is the
+// display id of the device and is filled in on output only.
+type lock struct {
+	Device  string    `json:"device"`
+	Owner   string    `json:"owner"`
+	TTL     int64     `json:"ttl"`
+	Expires time.Time `json:"expires"`
+
+	dev *Device
+}
+
+type lockRequest struct {
+	Owner string `json:"owner"`
+	TTL   int64  `json:"ttl"`
+}
+
+type lockManager struct {
+	mutex sync.Mutex
+	locks map[*Device]*lock
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks: make(map[*Device]*lock),
+	}
+}
+
+// get returns the active lock for d. Expired locks are
+// removed lazily on access.
+func (m *lockManager) get(d *Device) (*lock, bool) {
+	l, ok := m.locks[d]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(l.Expires) {
+		delete(m.locks, d)
+		return nil, false
+	}
+	return l, true
+}
+
+func (m *lockManager) acquire(d *Device, owner string, ttl time.Duration) (lock, error) {
+	if owner == "" {
+		return lock{}, fmt.Errorf("no lock owner specified")
+	}
+	if ttl <= 0 {
+		return lock{}, fmt.Errorf("invalid lock ttl: %s", ttl)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if l, ok := m.get(d); ok && l.Owner != owner {
+		return *l, fmt.Errorf("device '%s' is locked by '%s'", d.label(), l.Owner)
+	}
+	l := &lock{
+		Owner:   owner,
+		TTL:     ttl.Milliseconds(),
+		Expires: time.Now().Add(ttl),
+		dev:     d,
+	}
+	m.locks[d] = l
+	return *l, nil
+}
+
+func (m *lockManager) release(d *Device, owner string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, ok := m.get(d)
+	if !ok {
+		return nil
+	}
+	if l.Owner != owner {
+		return fmt.Errorf("device '%s' is locked by '%s'", d.label(), l.Owner)
+	}
+	delete(m.locks, d)
+	return nil
+}
+
+// check returns an error if d is locked by someone else than owner.
+func (m *lockManager) check(d *Device, owner string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if l, ok := m.get(d); ok && l.Owner != owner {
+		return fmt.Errorf("device '%s' is locked by '%s'", d.label(), l.Owner)
+	}
+	return nil
+}
+
+func (m *lockManager) lookup(d *Device) *lock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if l, ok := m.get(d); ok {
+		c := *l
+		return &c
+	}
+	return nil
+}
+
+func (m *lockManager) list() []lock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resp := make([]lock, 0, len(m.locks))
+	for d := range m.locks {
+		if l, ok := m.get(d); ok {
+			resp = append(resp, *l)
+		}
+	}
+	return resp
+}
+
+// lockMiddleware rejects mutating requests to locked devices
+// with 423 if the request does not originate from the lock owner.
+func (s *HTTPServer) lockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			route  = mux.CurrentRoute(r)
+			id, ok = mux.Vars(r)["id"]
+		)
+		if r.Method == http.MethodGet || !ok || (route != nil && route.GetName() == "lock") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d, _, err := s.Devices.Lookup(id)
+		if err != nil {
+			// The handler reports the unknown device.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := s.locks.check(d, clientIdentity(r)); err != nil {
+			sendErrorStatus(w, err.Error(), http.StatusLocked)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *HTTPServer) postLock(w http.ResponseWriter, r *http.Request) {
+	var req lockRequest
+	d, id, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Authenticated clients always lock in their own name.
+	if p := principalFromContext(r.Context()); p != nil || req.Owner == "" {
+		req.Owner = clientIdentity(r)
+	}
+	l, err := s.locks.acquire(d, req.Owner, time.Duration(req.TTL)*time.Millisecond)
+	if err != nil {
+		if l.Owner != "" {
+			sendErrorStatus(w, err.Error(), http.StatusLocked)
+			return
+		}
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.Device = id
+	s.Logger.LogInfof("device %s locked by '%s'", l.Device, l.Owner)
+	helpers.SendJSON(w, l)
+}
+
+func (s *HTTPServer) getLock(w http.ResponseWriter, r *http.Request) {
+	d, id, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	l := s.locks.lookup(d)
+	if l != nil {
+		l.Device = id
+	}
+	helpers.SendJSON(w, l)
+}
+
+func (s *HTTPServer) deleteLock(w http.ResponseWriter, r *http.Request) {
+	d, id, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	if err := s.locks.release(d, clientIdentity(r)); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusLocked)
+		return
+	}
+	s.Logger.LogInfof("device %s unlocked", id)
+}
+
+func (s *HTTPServer) getLocks(w http.ResponseWriter, r *http.Request) {
+	resp := []lock{}
+	for _, l := range s.locks.list() {
+		// Locks of removed devices are not listed.
+		id, ok := s.deviceID(l.dev)
+		if !ok || !s.mayAccessDevice(r, id) {
+			continue
+		}
+		l.Device = id
+		resp = append(resp, l)
+	}
+	helpers.SendJSON(w, resp)
+}
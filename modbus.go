@@ -0,0 +1,353 @@
+package opennetzteil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Modbus function codes.
+const (
+	modbusReadCoils              = 0x01
+	modbusReadHoldingRegisters   = 0x03
+	modbusReadInputRegisters     = 0x04
+	modbusWriteSingleCoil        = 0x05
+	modbusWriteSingleRegister    = 0x06
+	modbusWriteMultipleRegisters = 0x10
+	modbusException              = 0x80
+)
+
+// Modbus exception codes which map to errors of this package.
+var modbusExceptions = map[byte]struct {
+	err  error
+	name string
+}{
+	1: {ErrNotImplemented, "illegal function"},
+	2: {ErrOutOfRange, "illegal data address"},
+	3: {ErrOutOfRange, "illegal data value"},
+	4: {ErrTransport, "server device failure"},
+	6: {ErrBusy, "server device busy"},
+}
+
+// appendUint16 appends v in big endian byte order.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// CRC16 computes the Modbus CRC of data.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Modbus is a client for a single Modbus server, either via RTU,
+// e.g. on a serial line, or via TCP. A serial line must be
+// configured by the caller.
+type Modbus struct {
+	handle  io.ReadWriter
+	address byte
+	timeout time.Duration
+	tcp     bool
+	tid     uint16
+	// dirty is set after a failed RTU exchange; a late
+	// reply might still arrive on the line.
+	dirty bool
+	mutex sync.Mutex
+}
+
+// NewModbusRTU creates a client for the server with address. If handle
+// supports SetReadDeadline(), e.g. *os.File, responses time out.
+func NewModbusRTU(handle io.ReadWriter, address byte, timeout time.Duration) *Modbus {
+	return &Modbus{
+		handle:  handle,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// NewModbusTCP creates a client for the unit with address behind
+// conn, e.g. a net.Conn. Most servers ignore the address.
+func NewModbusTCP(conn io.ReadWriter, address byte, timeout time.Duration) *Modbus {
+	return &Modbus{
+		handle:  conn,
+		address: address,
+		timeout: timeout,
+		tcp:     true,
+	}
+}
+
+// SetHandle replaces the handle, e.g. after the device was reconnected.
+func (m *Modbus) SetHandle(handle io.ReadWriter) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handle = handle
+	m.dirty = false
+}
+
+func (m *Modbus) setDeadline() error {
+	if h, ok := m.handle.(interface{ SetReadDeadline(time.Time) error }); ok {
+		if err := h.SetReadDeadline(time.Now().Add(m.timeout)); err != nil {
+			return TransportError(err)
+		}
+	}
+	return nil
+}
+
+// rtuDrainGap is the time the line must be quiet
+// before a request is sent after a failed exchange.
+const rtuDrainGap = 50 * time.Millisecond
+
+// drain discards pending input, e.g. the late reply to a request which
+// timed out. RTU has no transaction ids; without draining, the late
+// reply would be read as the response to the next request. Handles
+// without deadline support cannot be drained.
+func (m *Modbus) drain() error {
+	h, ok := m.handle.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return nil
+	}
+	buf := make([]byte, 256)
+	for {
+		if err := h.SetReadDeadline(time.Now().Add(rtuDrainGap)); err != nil {
+			return TransportError(err)
+		}
+		if _, err := m.handle.Read(buf); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return TransportError(err)
+		}
+	}
+}
+
+// transaction sends a request and returns the payload of the response.
+// respLen is the length of the payload, or -1 if the payload starts with
+// a byte count.
+func (m *Modbus) transaction(function byte, payload []byte, respLen int) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.dirty {
+		if err := m.drain(); err != nil {
+			return nil, err
+		}
+		m.dirty = false
+	}
+	resp, err := m.exchange(function, payload, respLen)
+	// TCP replies carry the transaction id; see exchangeTCP().
+	if !m.tcp && (errors.Is(err, ErrTimeout) || errors.Is(err, ErrTransport)) {
+		m.dirty = true
+	}
+	return resp, err
+}
+
+func (m *Modbus) exchange(function byte, payload []byte, respLen int) ([]byte, error) {
+	if err := m.setDeadline(); err != nil {
+		return nil, err
+	}
+	var (
+		resp []byte
+		err  error
+	)
+	if m.tcp {
+		resp, err = m.exchangeTCP(function, payload)
+	} else {
+		resp, err = m.exchangeRTU(function, payload, respLen)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(resp) < 3:
+		return nil, fmt.Errorf("%w: modbus: short response", ErrTransport)
+	case resp[0] != m.address:
+		return nil, fmt.Errorf("%w: modbus: response from address %d", ErrTransport, resp[0])
+	case resp[1] == function|modbusException:
+		code := resp[2]
+		if e, ok := modbusExceptions[code]; ok {
+			return nil, fmt.Errorf("%w: modbus exception %d: %s", e.err, code, e.name)
+		}
+		return nil, fmt.Errorf("modbus exception %d", code)
+	case resp[1] != function:
+		return nil, fmt.Errorf("%w: modbus: unexpected function %#x", ErrTransport, resp[1])
+	}
+	return resp[2:], nil
+}
+
+// exchangeRTU returns the response without CRC.
+func (m *Modbus) exchangeRTU(function byte, payload []byte, respLen int) ([]byte, error) {
+	req := append([]byte{m.address, function}, payload...)
+	crc := CRC16(req)
+	req = append(req, byte(crc), byte(crc>>8))
+	if _, err := m.handle.Write(req); err != nil {
+		return nil, TransportError(err)
+	}
+
+	resp := make([]byte, 3)
+	if _, err := io.ReadFull(m.handle, resp); err != nil {
+		return nil, TransportError(err)
+	}
+	var n int
+	switch {
+	case resp[1] == function|modbusException:
+		n = 2
+	case respLen < 0:
+		n = int(resp[2]) + 2
+	default:
+		n = respLen - 1 + 2
+	}
+	rest := make([]byte, n)
+	if _, err := io.ReadFull(m.handle, rest); err != nil {
+		return nil, TransportError(err)
+	}
+	resp = append(resp, rest...)
+	if CRC16(resp[:len(resp)-2]) != binary.LittleEndian.Uint16(resp[len(resp)-2:]) {
+		return nil, fmt.Errorf("%w: modbus: crc mismatch", ErrTransport)
+	}
+	return resp[:len(resp)-2], nil
+}
+
+// exchangeTCP returns the response without MBAP header
+// but with the unit address.
+func (m *Modbus) exchangeTCP(function byte, payload []byte) ([]byte, error) {
+	m.tid++
+
+	var req []byte
+	req = appendUint16(req, m.tid)
+	req = appendUint16(req, 0) // protocol
+	req = appendUint16(req, uint16(len(payload)+2))
+	req = append(req, m.address, function)
+	req = append(req, payload...)
+	if _, err := m.handle.Write(req); err != nil {
+		return nil, TransportError(err)
+	}
+
+	// Replies to earlier requests which timed out might still be
+	// in the buffer; skip them until the reply to req arrives.
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(m.handle, header); err != nil {
+			return nil, TransportError(err)
+		}
+		n := binary.BigEndian.Uint16(header[4:])
+		if n < 1 {
+			return nil, fmt.Errorf("%w: modbus: invalid length %d", ErrTransport, n)
+		}
+		resp := make([]byte, n)
+		if _, err := io.ReadFull(m.handle, resp); err != nil {
+			return nil, TransportError(err)
+		}
+		tid := binary.BigEndian.Uint16(header)
+		switch {
+		case tid == m.tid:
+			return resp, nil
+		// The transaction id wraps around.
+		case int16(m.tid-tid) > 0:
+			continue
+		default:
+			return nil, fmt.Errorf("%w: modbus: unexpected transaction %d", ErrTransport, tid)
+		}
+	}
+}
+
+func (m *Modbus) readRegisters(function byte, addr, count uint16) ([]uint16, error) {
+	var payload []byte
+	payload = appendUint16(payload, addr)
+	payload = appendUint16(payload, count)
+	resp, err := m.transaction(function, payload, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != 1+2*int(count) {
+		return nil, fmt.Errorf("%w: modbus: read %d bytes; expected %d", ErrTransport, len(resp)-1, 2*count)
+	}
+	regs := make([]uint16, count)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(resp[1+2*i:])
+	}
+	return regs, nil
+}
+
+// ReadRegisters reads count holding registers starting at addr.
+func (m *Modbus) ReadRegisters(addr, count uint16) ([]uint16, error) {
+	return m.readRegisters(modbusReadHoldingRegisters, addr, count)
+}
+
+// ReadInputRegisters reads count input registers starting at addr.
+func (m *Modbus) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	return m.readRegisters(modbusReadInputRegisters, addr, count)
+}
+
+// ReadRegister reads the holding register at addr.
+func (m *Modbus) ReadRegister(addr uint16) (uint16, error) {
+	regs, err := m.ReadRegisters(addr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return regs[0], nil
+}
+
+// WriteRegister writes value to the holding register at addr.
+func (m *Modbus) WriteRegister(addr, value uint16) error {
+	var payload []byte
+	payload = appendUint16(payload, addr)
+	payload = appendUint16(payload, value)
+	_, err := m.transaction(modbusWriteSingleRegister, payload, 4)
+	return err
+}
+
+// WriteRegisters writes values to the holding registers starting at addr.
+func (m *Modbus) WriteRegisters(addr uint16, values []uint16) error {
+	var payload []byte
+	payload = appendUint16(payload, addr)
+	payload = appendUint16(payload, uint16(len(values)))
+	payload = append(payload, byte(2*len(values)))
+	for _, v := range values {
+		payload = appendUint16(payload, v)
+	}
+	_, err := m.transaction(modbusWriteMultipleRegisters, payload, 4)
+	return err
+}
+
+// ReadCoil reads the coil at addr.
+func (m *Modbus) ReadCoil(addr uint16) (bool, error) {
+	var payload []byte
+	payload = appendUint16(payload, addr)
+	payload = appendUint16(payload, 1)
+	resp, err := m.transaction(modbusReadCoils, payload, -1)
+	if err != nil {
+		return false, err
+	}
+	if len(resp) != 2 {
+		return false, fmt.Errorf("%w: modbus: read %d bytes; expected 1", ErrTransport, len(resp)-1)
+	}
+	return resp[1]&1 != 0, nil
+}
+
+// WriteCoil writes the coil at addr.
+func (m *Modbus) WriteCoil(addr uint16, value bool) error {
+	var payload []byte
+	payload = appendUint16(payload, addr)
+	if value {
+		payload = appendUint16(payload, 0xff00)
+	} else {
+		payload = appendUint16(payload, 0)
+	}
+	_, err := m.transaction(modbusWriteSingleCoil, payload, 4)
+	return err
+}
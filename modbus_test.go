@@ -0,0 +1,88 @@
+package opennetzteil_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rumpelsepp/opennetzteil"
+)
+
+func TestModbusTCPStaleReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// The first request times out; its reply arrives
+	// just before the reply to the second one.
+	go func() {
+		var tids []uint16
+		for i := 0; i < 2; i++ {
+			req := make([]byte, 12)
+			if _, err := io.ReadFull(server, req); err != nil {
+				return
+			}
+			tids = append(tids, binary.BigEndian.Uint16(req))
+		}
+		for i, tid := range tids {
+			resp := []byte{0, 0, 0, 0, 0, 5, 1, 0x03, 2, 0, byte(i + 1)}
+			binary.BigEndian.PutUint16(resp, tid)
+			server.Write(resp)
+		}
+	}()
+
+	m := opennetzteil.NewModbusTCP(client, 1, 100*time.Millisecond)
+	if _, err := m.ReadRegister(0); err == nil {
+		t.Fatal("first request did not time out")
+	}
+	v, err := m.ReadRegister(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Errorf("got reply %d of the stale request", v)
+	}
+}
+
+func TestModbusRTUStaleReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	reply := func(value byte) []byte {
+		resp := []byte{1, 0x03, 2, 0, value}
+		crc := opennetzteil.CRC16(resp)
+		return append(resp, byte(crc), byte(crc>>8))
+	}
+	// The reply to the first request arrives after the timeout,
+	// before the second request is sent.
+	go func() {
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(server, req); err != nil {
+			return
+		}
+		time.Sleep(150 * time.Millisecond)
+		// Without draining, the client never reads the reply.
+		server.SetWriteDeadline(time.Now().Add(time.Second))
+		server.Write(reply(1))
+		if _, err := io.ReadFull(server, req); err != nil {
+			return
+		}
+		server.Write(reply(2))
+	}()
+
+	m := opennetzteil.NewModbusRTU(client, 1, 100*time.Millisecond)
+	if _, err := m.ReadRegister(0); err == nil {
+		t.Fatal("first request did not time out")
+	}
+	time.Sleep(100 * time.Millisecond)
+	v, err := m.ReadRegister(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Errorf("got reply %d of the stale request", v)
+	}
+}
@@ -3,7 +3,6 @@ package opennetzteil
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,10 +11,6 @@ import (
 	"time"
 )
 
-var (
-	ErrNotImplemented = errors.New("endpoint not implemented")
-)
-
 type Netzteil interface {
 	Probe() error
 	Status() (interface{}, error)
@@ -36,25 +31,189 @@ type Netzteil interface {
 	SetOVP(channel int, enabled bool) error
 }
 
+// MasterChannel addresses the master output. It is only valid
+// for the output state; all other channel functions use the
+// channels 1 to GetChannels().
+const MasterChannel = 0
+
+// CheckChannel returns ErrInvalidChannel if channel is not
+// within 1 and channels.
+func CheckChannel(channel, channels int) error {
+	if channel < 1 || channel > channels {
+		return fmt.Errorf("%w: '%d'; device has '%d' channels", ErrInvalidChannel, channel, channels)
+	}
+	return nil
+}
+
+// Capabilities which drivers can report via the Capabler interface.
+const (
+	CapabilityStatus = "status"
+	CapabilityMaster = "master"
+	CapabilityBeep   = "beep"
+	CapabilityOCP    = "ocp"
+	CapabilityOVP    = "ovp"
+	CapabilityPreset = "preset"
+	// CapabilityTracking is reported by drivers implementing Tracker.
+	CapabilityTracking = "tracking"
+	// CapabilityTimer is reported by drivers implementing Timer.
+	CapabilityTimer = "timer"
+	// CapabilityPanelLock is reported by drivers implementing PanelLocker.
+	CapabilityPanelLock = "panel_lock"
+	// CapabilityFuse is reported by drivers implementing ElectronicFuse.
+	CapabilityFuse = "fuse"
+)
+
+// Capabler is implemented by drivers which report the optional
+// features they support, e.g. CapabilityOCP.
+type Capabler interface {
+	Capabilities() []string
+}
+
+// Rating describes the maximum output values of a channel.
+type Rating struct {
+	MaxVoltage float64 `json:"max_voltage"`
+	MaxCurrent float64 `json:"max_current"`
+	MaxPower   float64 `json:"max_power,omitempty"`
+}
+
+// Rater is implemented by drivers which know the ratings of their channels.
+type Rater interface {
+	GetRating(channel int) (Rating, error)
+}
+
+// Regulation modes reported via the ModeReader interface.
+const (
+	ModeCC = "CC"
+	ModeCV = "CV"
+)
+
+// ModeReader is implemented by drivers which report whether
+// a channel is in constant current or constant voltage mode.
+type ModeReader interface {
+	GetMode(channel int) (string, error)
+}
+
+// SetpointReader is implemented by drivers which can read back the
+// voltage and current setpoints of a channel. GetVoltage() and
+// GetCurrent() report measured values.
+type SetpointReader interface {
+	GetVoltageSetpoint(channel int) (float64, error)
+	GetCurrentSetpoint(channel int) (float64, error)
+}
+
+// ProtectionReporter is implemented by drivers which report whether
+// the over current or over voltage protection of a channel tripped.
+type ProtectionReporter interface {
+	GetProtectionTripped(channel int) (bool, error)
+}
+
+// Pinger is implemented by drivers which can check whether the device
+// responds without modifying the driver state, e.g. via *IDN?. Probe()
+// is only called on connect; health checks use Ping() instead.
+type Pinger interface {
+	Ping() error
+}
+
+// Presetter is implemented by drivers which can save the setpoints
+// to the memories of the device and recall them. The presets are
+// numbered from 1 to GetPresets().
+type Presetter interface {
+	GetPresets() (int, error)
+	SavePreset(preset int) error
+	RecallPreset(preset int) error
+}
+
+// Tracking modes of dual channel devices; see Tracker.
+const (
+	TrackingIndependent = "independent"
+	TrackingSeries      = "series"
+	TrackingParallel    = "parallel"
+)
+
+// Tracker is implemented by drivers of devices which can connect
+// two channels in series or in parallel. mode is one of
+// TrackingIndependent, TrackingSeries, or TrackingParallel.
+type Tracker interface {
+	SetTracking(mode string) error
+}
+
+// TimerStep is a step of the timer mode; see Timer.
+type TimerStep struct {
+	Voltage  float64
+	Current  float64
+	Duration time.Duration
+}
+
+// Timer is implemented by drivers of devices which can run a
+// sequence of setpoints on a channel by themselves.
+type Timer interface {
+	SetTimer(channel int, steps []TimerStep) error
+	EnableTimer(channel int, enabled bool) error
+}
+
+// PanelLocker is implemented by drivers of devices whose
+// front panel can be locked against manual operation.
+type PanelLocker interface {
+	SetPanelLock(locked bool) error
+}
+
+// ElectronicFuse is implemented by drivers of devices whose OCP is
+// an electronic fuse with a trip delay. If the fuse of a channel
+// trips, the outputs of the channels linked to it are switched off.
+type ElectronicFuse interface {
+	GetFuseDelay(channel int) (time.Duration, error)
+	SetFuseDelay(channel int, delay time.Duration) error
+	LinkFuse(channel, other int) error
+	UnlinkFuse(channel, other int) error
+}
+
+// CheckPreset returns ErrOutOfRange if preset is not
+// within 1 and presets.
+func CheckPreset(preset, presets int) error {
+	if preset < 1 || preset > presets {
+		return fmt.Errorf("%w: preset '%d'; device has '%d' presets", ErrOutOfRange, preset, presets)
+	}
+	return nil
+}
+
 type NetzteilBase struct {
-	mutex sync.Mutex
-	Name  string
-	Ident string
+	mutex      sync.Mutex
+	identMutex sync.Mutex
+	Name       string
+	Ident      string
 }
 
 func (nt *NetzteilBase) GetIdent() (string, error) {
+	nt.identMutex.Lock()
+	defer nt.identMutex.Unlock()
 	if nt.Name != "" {
 		return fmt.Sprintf("%s (%s)", nt.Ident, nt.Name), nil
 	}
 	return nt.Ident, nil
 }
 
+// RawIdent returns the identity as reported by the device,
+// without the configured name.
+func (nt *NetzteilBase) RawIdent() string {
+	nt.identMutex.Lock()
+	defer nt.identMutex.Unlock()
+	return nt.Ident
+}
+
+// SetIdent updates the identity of the device. Drivers call it from
+// Probe(), which is called on each connect.
+func (nt *NetzteilBase) SetIdent(ident string) {
+	nt.identMutex.Lock()
+	defer nt.identMutex.Unlock()
+	nt.Ident = ident
+}
+
 func (nt *NetzteilBase) SendCommand(handle io.Writer, cmd []byte) error {
 	nt.mutex.Lock()
 	defer nt.mutex.Unlock()
 	_, err := io.Copy(handle, bytes.NewReader(cmd))
 	if err != nil {
-		return err
+		return TransportError(err)
 	}
 	return nil
 }
@@ -68,13 +227,13 @@ func (nt *NetzteilBase) Request(handle io.ReadWriter, cmd []byte) ([]byte, error
 	defer nt.mutex.Unlock()
 	_, err := io.Copy(handle, bytes.NewReader(cmd))
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 
 	var buf bytes.Buffer
 	_, err = io.Copy(&buf, handle)
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 	return buf.Bytes(), nil
 }
@@ -84,23 +243,41 @@ func (nt *NetzteilBase) RequestLine(handle io.ReadWriter, cmd []byte) ([]byte, e
 	defer nt.mutex.Unlock()
 	_, err := io.Copy(handle, bytes.NewReader(append(cmd, '\n')))
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 
 	reader := bufio.NewReader(handle)
 	line, _, err := reader.ReadLine()
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 	return line, nil
 }
 
+// RequestUntil sends cmd and reads the response up to delim. The
+// response is returned without delim.
+func (nt *NetzteilBase) RequestUntil(handle io.ReadWriter, cmd []byte, delim byte) ([]byte, error) {
+	nt.mutex.Lock()
+	defer nt.mutex.Unlock()
+	_, err := io.Copy(handle, bytes.NewReader(cmd))
+	if err != nil {
+		return nil, TransportError(err)
+	}
+
+	reader := bufio.NewReader(handle)
+	resp, err := reader.ReadBytes(delim)
+	if err != nil {
+		return nil, TransportError(err)
+	}
+	return resp[:len(resp)-1], nil
+}
+
 func (nt *NetzteilBase) RequestWithTimeout(handle io.ReadWriter, cmd []byte, timeout time.Duration) ([]byte, error) {
 	nt.mutex.Lock()
 	defer nt.mutex.Unlock()
 	_, err := io.Copy(handle, bytes.NewReader(cmd))
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 	var (
 		outBuf []byte
@@ -129,7 +306,7 @@ func (nt *NetzteilBase) RequestWithTimeout(handle io.ReadWriter, cmd []byte, tim
 			if os.IsTimeout(err) {
 				return outBuf, nil
 			}
-			return nil, err
+			return nil, TransportError(err)
 		}
 	}
 }
@@ -142,7 +319,7 @@ func (nt *NetzteilBase) RequestWithTimeout(handle io.ReadWriter, cmd []byte, tim
 func (nt *NetzteilBase) TCPSend(target, cmd string) error {
 	conn, err := net.Dial("tcp", target)
 	if err != nil {
-		return err
+		return TransportError(err)
 	}
 	defer conn.Close()
 	return nt.SendCommandLine(conn, []byte(cmd))
@@ -153,7 +330,7 @@ func (nt *NetzteilBase) TCPSend(target, cmd string) error {
 func (nt *NetzteilBase) TCPSendBatched(target string, cmd []string) error {
 	conn, err := net.Dial("tcp", target)
 	if err != nil {
-		return err
+		return TransportError(err)
 	}
 	defer conn.Close()
 	for _, cmd := range cmd {
@@ -170,7 +347,7 @@ func (nt *NetzteilBase) TCPSendBatched(target string, cmd []string) error {
 func (nt *NetzteilBase) TCPRequest(target, cmd string) ([]byte, error) {
 	conn, err := net.Dial("tcp", target)
 	if err != nil {
-		return nil, err
+		return nil, TransportError(err)
 	}
 	defer conn.Close()
 	return nt.RequestLine(conn, []byte(cmd))
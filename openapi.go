@@ -0,0 +1,16 @@
+package opennetzteil
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI is the OpenAPI description of the HTTP API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+func (s *HTTPServer) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPI)
+}
@@ -0,0 +1,49 @@
+package opennetzteil
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// panelLocker returns the driver of d if it can lock the front panel.
+func (d *Device) panelLocker() (PanelLocker, error) {
+	drv, err := d.driver()
+	if err != nil {
+		return nil, err
+	}
+	l, ok := drv.(PanelLocker)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return l, nil
+}
+
+func (d *Device) setPanelLock(a actor, locked bool) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		l, err := d.panelLocker()
+		if err != nil {
+			return err
+		}
+		err = l.SetPanelLock(locked)
+		d.audit(a, "SetPanelLock", MasterChannel, nil, locked, err)
+		return err
+	})
+}
+
+func (s *HTTPServer) putPanelLock(w http.ResponseWriter, r *http.Request) {
+	var req bool
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.setPanelLock(requestActor(r), req); err != nil {
+		sendError(w, err)
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package opennetzteil
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+func parsePreset(vars map[string]string) (int, error) {
+	preset, err := strconv.Atoi(vars["preset"])
+	if err != nil {
+		return 0, fmt.Errorf("%w: preset '%s'", ErrOutOfRange, vars["preset"])
+	}
+	return preset, nil
+}
+
+// presetter returns the driver of d if it supports presets.
+func (d *Device) presetter() (Presetter, error) {
+	drv, err := d.driver()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := drv.(Presetter)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return p, nil
+}
+
+func (d *Device) savePreset(a actor, preset int) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		p, err := d.presetter()
+		if err != nil {
+			return err
+		}
+		err = p.SavePreset(preset)
+		d.audit(a, "SavePreset", MasterChannel, nil, preset, err)
+		return err
+	})
+}
+
+// recallPreset recalls preset. The recorded voltage and current
+// setpoints are dropped, since the device applied those of the preset.
+func (d *Device) recallPreset(a actor, preset int) error {
+	err := d.transaction(a, func(nt Netzteil) error {
+		p, err := d.presetter()
+		if err != nil {
+			return err
+		}
+		err = p.RecallPreset(preset)
+		d.audit(a, "RecallPreset", MasterChannel, nil, preset, err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	for _, sp := range d.setpoints {
+		sp.Voltage = nil
+		sp.Current = nil
+	}
+	d.mutex.Unlock()
+
+	d.publish(a, EventPreset, MasterChannel, preset)
+	return nil
+}
+
+func (s *HTTPServer) getPresets(w http.ResponseWriter, r *http.Request) {
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	p, err := d.presetter()
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	presets, err := p.GetPresets()
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, presets)
+}
+
+func (s *HTTPServer) postPreset(save bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		d, _, err := s.lookupDeviceEntry(w, vars)
+		if err != nil {
+			return
+		}
+		preset, err := parsePreset(vars)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+		if save {
+			err = d.savePreset(requestActor(r), preset)
+		} else {
+			err = d.recallPreset(requestActor(r), preset)
+		}
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+	}
+}
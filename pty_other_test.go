@@ -0,0 +1,12 @@
+//go:build !linux
+
+package opennetzteil_test
+
+import (
+	"errors"
+	"os"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("pseudo terminals are only supported on linux")
+}
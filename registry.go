@@ -0,0 +1,340 @@
+package opennetzteil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+)
+
+var (
+	ErrDeviceOffline = errors.New("device offline")
+)
+
+// Device is a power supply managed by a Registry. The driver
+// instance is created via Open and might change over time, e.g.
+// when the device was offline and reconnected.
+type Device struct {
+	// Key identifies the device configuration. Devices with
+	// the same key are considered equal on Registry.Update().
+	Key    string
+	Name   string
+	Model  string
+	Handle string
+	Open   func() (Netzteil, error)
+	// RestoreSetpoints re-applies the last setpoints
+	// after the device was reconnected.
+	RestoreSetpoints bool
+
+	mutex     sync.Mutex
+	txMutex   sync.Mutex // serializes multi-step operations
+	nt        Netzteil
+	ident     Ident
+	health    Health
+	setpoints map[int]*channelSetpoints
+	tripped   map[int]bool
+	events    *eventBus
+	auditLog  *AuditLog
+	stop      chan struct{}
+
+	// pendingOff lists the outputs of expired leases which could
+	// not be switched off, with the lease the command is audited as.
+	pendingOff map[int]actor
+}
+
+// Netzteil returns the driver instance of a device which is not offline.
+// Setpoints changed via the returned instance are recorded.
+func (d *Device) Netzteil() (Netzteil, error) {
+	return d.netzteilAs(actor{})
+}
+
+// netzteilAs is like Netzteil; commands are audited as sent by a.
+func (d *Device) netzteilAs(a actor) (Netzteil, error) {
+	return d.wrap(a, false)
+}
+
+// wrap returns the recording driver instance; tx must be
+// set if the caller holds the txMutex.
+func (d *Device) wrap(a actor, tx bool) (Netzteil, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.nt == nil {
+		if d.health.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrDeviceOffline, d.health.Error)
+		}
+		return nil, ErrDeviceOffline
+	}
+	return &recordingNetzteil{Netzteil: d.nt, d: d, actor: a, tx: tx}, nil
+}
+
+// driver returns the unwrapped driver instance. It is used for
+// type assertions of optional interfaces, such as Rater.
+func (d *Device) driver() (Netzteil, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.nt == nil {
+		return nil, ErrDeviceOffline
+	}
+	return d.nt, nil
+}
+
+func (d *Device) connect() error {
+	nt, err := d.Open()
+	if err != nil {
+		d.setOffline(err)
+		return err
+	}
+	if err := nt.Probe(); err != nil {
+		closeNetzteil(nt)
+		err = fmt.Errorf("probe failed: %w", err)
+		d.setOffline(err)
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// The device might have been removed in the meantime.
+	select {
+	case <-d.stop:
+		closeNetzteil(nt)
+		return fmt.Errorf("device removed")
+	default:
+	}
+	if !d.health.LastSeen.IsZero() {
+		d.health.Reconnects++
+	}
+	d.ident = rawIdent(nt)
+	d.nt = nt
+	d.health.State = StateOnline
+	d.health.Error = ""
+	d.health.Failures = 0
+	d.health.LastSeen = time.Now()
+	return nil
+}
+
+// ID returns the stable identifier of the device. This is the
+// configured name or, if unset, the serial number of the device.
+// The ID is empty if neither is available.
+func (d *Device) ID() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.ident.Serial
+}
+
+// label returns the ID of the device or, if empty, its Key.
+// It is used to refer to the device in events and the audit log.
+func (d *Device) label() string {
+	if id := d.ID(); id != "" {
+		return id
+	}
+	return d.Key
+}
+
+// Ident returns the parsed identity of the device. It
+// is cached from the last successful connection.
+func (d *Device) Ident() Ident {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.ident
+}
+
+func rawIdent(nt Netzteil) Ident {
+	if r, ok := nt.(interface{ RawIdent() string }); ok {
+		return ParseIdent(r.RawIdent())
+	}
+	ident, err := nt.GetIdent()
+	if err != nil {
+		return Ident{}
+	}
+	return ParseIdent(ident)
+}
+
+func (d *Device) setOffline(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.nt != nil {
+		closeNetzteil(d.nt)
+	}
+	d.nt = nil
+	d.health.State = StateOffline
+	d.health.Error = err.Error()
+}
+
+func (d *Device) close() {
+	close(d.stop)
+	d.setOffline(fmt.Errorf("device removed"))
+	d.publish(actor{}, EventState, 0, StateOffline)
+}
+
+func closeNetzteil(nt Netzteil) {
+	if c, ok := nt.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// Registry is the list of devices served by the HTTPServer.
+// Each device is supervised in the background; devices whose
+// probe fails are kept as offline and are reconnected.
+type Registry struct {
+	// HealthInterval is the time between two health checks.
+	HealthInterval time.Duration
+	// RetryInterval is the initial reconnect interval of offline
+	// devices. It is doubled on each failed attempt up to
+	// MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	Logger           *penlogger.Logger
+	// Audit records all state-changing commands if set.
+	Audit *AuditLog
+
+	// updateMutex serializes calls to Update().
+	updateMutex sync.Mutex
+	mutex       sync.RWMutex
+	devices     []*Device
+	events      eventBus
+}
+
+func NewRegistry(logger *penlogger.Logger) *Registry {
+	return &Registry{
+		HealthInterval:   10 * time.Second,
+		RetryInterval:    5 * time.Second,
+		MaxRetryInterval: 5 * time.Minute,
+		Logger:           logger,
+	}
+}
+
+// Devices returns a snapshot of the device list.
+func (r *Registry) Devices() []*Device {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	devices := make([]*Device, len(r.devices))
+	copy(devices, r.devices)
+	return devices
+}
+
+// Lookup resolves a device id. The id is either the 1-based
+// position in the device list, the configured name, or the serial
+// number of the device. The position is returned as well.
+func (r *Registry) Lookup(id string) (*Device, int, error) {
+	devices := r.Devices()
+	if n, err := strconv.Atoi(id); err == nil {
+		if n < 1 || n > len(devices) {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+		}
+		return devices[n-1], n, nil
+	}
+	for i, d := range devices {
+		if d.Name == id {
+			return d, i + 1, nil
+		}
+	}
+	for i, d := range devices {
+		if d.Ident().Serial == id {
+			return d, i + 1, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+}
+
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.devices)
+}
+
+// CheckDevices returns an error if two devices share a key or a
+// name. Such devices cannot be told apart by Update() and Lookup().
+// Numeric names are rejected as well; Lookup() treats them as positions.
+func CheckDevices(devices []*Device) error {
+	var (
+		keys  = make(map[string]bool)
+		names = make(map[string]bool)
+	)
+	for _, d := range devices {
+		if keys[d.Key] {
+			return fmt.Errorf("duplicate device: %s", d.Key)
+		}
+		keys[d.Key] = true
+		if d.Name == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(d.Name); err == nil {
+			return fmt.Errorf("invalid device name: %s; numbers are reserved for positions", d.Name)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("duplicate device name: %s", d.Name)
+		}
+		names[d.Name] = true
+	}
+	return nil
+}
+
+// Update replaces the device list with devices. Devices with a key
+// already present in the registry are kept untouched, removed devices
+// are closed, and new devices are connected. If devices fails
+// CheckDevices(), the registry is left unchanged.
+func (r *Registry) Update(devices []*Device) error {
+	var (
+		added []*Device
+		list  []*Device
+	)
+
+	if err := CheckDevices(devices); err != nil {
+		return err
+	}
+
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+
+	r.mutex.Lock()
+	old := make(map[string]*Device)
+	for _, d := range r.devices {
+		old[d.Key] = d
+	}
+	for _, d := range devices {
+		if o, ok := old[d.Key]; ok {
+			list = append(list, o)
+			delete(old, d.Key)
+			continue
+		}
+		d.stop = make(chan struct{})
+		d.events = &r.events
+		d.auditLog = r.Audit
+		d.health.State = StateOffline
+		list = append(list, d)
+		added = append(added, d)
+	}
+	r.devices = list
+	r.mutex.Unlock()
+
+	for _, d := range old {
+		r.Logger.LogInfof("device %s removed", d.Key)
+		d.close()
+	}
+	for _, d := range added {
+		if err := d.connect(); err != nil {
+			r.Logger.LogWarningf("device %s is offline: %s", d.Key, err)
+		} else {
+			r.Logger.LogInfof("device %s added", d.Key)
+		}
+		go d.supervise(r)
+	}
+	return nil
+}
+
+// Close closes all devices.
+func (r *Registry) Close() {
+	r.Update(nil)
+}
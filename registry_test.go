@@ -0,0 +1,44 @@
+package opennetzteil_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Fraunhofer-AISEC/penlogger"
+	"github.com/rumpelsepp/opennetzteil"
+	"github.com/rumpelsepp/opennetzteil/devices/dummy"
+)
+
+func TestRegistryDuplicates(t *testing.T) {
+	open := func() (opennetzteil.Netzteil, error) {
+		return &dummy.DummyDevice{}, nil
+	}
+	registry := opennetzteil.NewRegistry(penlogger.NewLogger("devices", io.Discard))
+	defer registry.Close()
+
+	if err := registry.Update([]*opennetzteil.Device{{Key: "a", Name: "psu", Open: open}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, devices := range [][]*opennetzteil.Device{
+		{{Key: "a", Name: "psu", Open: open}, {Key: "a", Open: open}},
+		{{Key: "a", Name: "psu", Open: open}, {Key: "b", Name: "psu", Open: open}},
+	} {
+		if err := registry.Update(devices); err == nil {
+			t.Errorf("duplicate devices %s, %s accepted", devices[0].Key, devices[1].Key)
+		}
+	}
+	// Lookup() would resolve numeric names as positions.
+	for _, name := range []string{"1", "42", "+2"} {
+		if err := registry.Update([]*opennetzteil.Device{{Key: "c", Name: name, Open: open}}); err == nil {
+			t.Errorf("numeric name %s accepted", name)
+		}
+	}
+	// The running configuration is kept.
+	if d := registry.Devices(); len(d) != 1 || d[0].Name != "psu" {
+		t.Fatalf("device list changed: %v", d)
+	}
+	// Unnamed devices do not conflict.
+	if err := registry.Update([]*opennetzteil.Device{{Key: "a", Open: open}, {Key: "b", Open: open}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,455 @@
+package opennetzteil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// channelState is the state of a channel as reported by the device.
+// Voltage and current are measured values; the setpoints are read
+// from the device or, if the driver cannot read them back, the values
+// last set via the API; they are null if unknown. Mode is empty and
+// OCP, OVP, and Tripped are null if the driver does not support them.
+type channelState struct {
+	Channel         int       `json:"channel"`
+	Time            time.Time `json:"time"`
+	VoltageSetpoint *float64  `json:"voltage_setpoint"`
+	CurrentSetpoint *float64  `json:"current_setpoint"`
+	Voltage         float64   `json:"voltage"`
+	Current         float64   `json:"current"`
+	Out             bool      `json:"out"`
+	Mode            string    `json:"mode,omitempty"`
+	OCP             *bool     `json:"ocp"`
+	OVP             *bool     `json:"ovp"`
+	Tripped         *bool     `json:"tripped"`
+}
+
+type deviceState struct {
+	Time     time.Time      `json:"time"`
+	Out      bool           `json:"out"`
+	Channels []channelState `json:"channels"`
+}
+
+// deviceSettings is the request body of the device-wide bulk update.
+// The master output is set via Out; Channels is keyed by channel number.
+type deviceSettings struct {
+	Out      *bool                    `json:"out,omitempty"`
+	Channels map[int]channelSetpoints `json:"channels"`
+}
+
+// transaction runs f with exclusive access to the device. It is
+// used for operations consisting of several driver calls. All other
+// state-changing commands sent via Device.Netzteil() wait for the
+// transaction; queries do not.
+func (d *Device) transaction(a actor, f func(nt Netzteil) error) error {
+	d.txMutex.Lock()
+	defer d.txMutex.Unlock()
+
+	nt, err := d.wrap(a, true)
+	if err != nil {
+		return err
+	}
+	return f(nt)
+}
+
+// setpoint returns a copy of the recorded setpoints of channel.
+func (d *Device) setpoint(channel int) channelSetpoints {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if sp, ok := d.setpoints[channel]; ok {
+		return *sp
+	}
+	return channelSetpoints{}
+}
+
+func optional(val bool, err error) (*bool, error) {
+	if errors.Is(err, ErrNotImplemented) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
+// readSetpoints returns the voltage and current setpoints of channel.
+// They are read from the device if the driver implements SetpointReader;
+// otherwise, the recorded setpoints are returned. Unknown values are nil.
+func (d *Device) readSetpoints(channel int) (*float64, *float64, error) {
+	sp := d.setpoint(channel)
+	drv, err := d.driver()
+	if err != nil {
+		return nil, nil, err
+	}
+	r, ok := drv.(SetpointReader)
+	if !ok {
+		return sp.Voltage, sp.Current, nil
+	}
+	read := func(get func(int) (float64, error), recorded *float64) (*float64, error) {
+		val, err := get(channel)
+		if errors.Is(err, ErrNotImplemented) {
+			return recorded, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &val, nil
+	}
+	voltage, err := read(r.GetVoltageSetpoint, sp.Voltage)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := read(r.GetCurrentSetpoint, sp.Current)
+	if err != nil {
+		return nil, nil, err
+	}
+	return voltage, current, nil
+}
+
+// readChannelState must be called within a transaction.
+func (d *Device) readChannelState(nt Netzteil, channel int) (channelState, error) {
+	var (
+		state = channelState{
+			Channel: channel,
+			Time:    time.Now(),
+		}
+		err error
+	)
+	if state.VoltageSetpoint, state.CurrentSetpoint, err = d.readSetpoints(channel); err != nil {
+		return state, err
+	}
+	if state.Voltage, err = nt.GetVoltage(channel); err != nil {
+		return state, err
+	}
+	if state.Current, err = nt.GetCurrent(channel); err != nil {
+		return state, err
+	}
+	if state.Out, err = nt.GetOut(channel); err != nil {
+		return state, err
+	}
+	if state.OCP, err = optional(nt.GetOCP(channel)); err != nil {
+		return state, err
+	}
+	if state.OVP, err = optional(nt.GetOVP(channel)); err != nil {
+		return state, err
+	}
+	drv, err := d.driver()
+	if err != nil {
+		return state, err
+	}
+	if m, ok := drv.(ModeReader); ok {
+		state.Mode, err = m.GetMode(channel)
+		if err != nil && !errors.Is(err, ErrNotImplemented) {
+			return state, err
+		}
+	}
+	if p, ok := drv.(ProtectionReporter); ok {
+		if state.Tripped, err = optional(p.GetProtectionTripped(channel)); err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+// readDeviceState must be called within a transaction.
+func (d *Device) readDeviceState(nt Netzteil) (deviceState, error) {
+	var (
+		state = deviceState{Time: time.Now()}
+		err   error
+	)
+	if state.Out, err = nt.GetMaster(); err != nil {
+		return state, err
+	}
+	nChannels, err := nt.GetChannels()
+	if err != nil {
+		return state, err
+	}
+	for ch := 1; ch <= nChannels; ch++ {
+		cs, err := d.readChannelState(nt, ch)
+		if err != nil {
+			return state, err
+		}
+		state.Channels = append(state.Channels, cs)
+	}
+	return state, nil
+}
+
+// snapshotSetpoints returns the present values of all settings in sps,
+// as read from the device. Voltage and current setpoints are only
+// included if the driver can read them back or if they were recorded.
+func (d *Device) snapshotSetpoints(nt Netzteil, sps map[int]channelSetpoints) (map[int]channelSetpoints, error) {
+	old := make(map[int]channelSetpoints)
+	for ch, sp := range sps {
+		var o channelSetpoints
+		if ch == MasterChannel {
+			if sp.Out != nil {
+				val, err := nt.GetMaster()
+				if err != nil {
+					return nil, err
+				}
+				o.Out = &val
+			}
+			old[ch] = o
+			continue
+		}
+		if sp.Voltage != nil || sp.Current != nil {
+			voltage, current, err := d.readSetpoints(ch)
+			if err != nil {
+				return nil, err
+			}
+			if sp.Voltage != nil {
+				o.Voltage = voltage
+			}
+			if sp.Current != nil {
+				o.Current = current
+			}
+		}
+		if sp.OCP != nil {
+			val, err := nt.GetOCP(ch)
+			if err != nil {
+				return nil, err
+			}
+			o.OCP = &val
+		}
+		if sp.OVP != nil {
+			val, err := nt.GetOVP(ch)
+			if err != nil {
+				return nil, err
+			}
+			o.OVP = &val
+		}
+		if sp.Out != nil {
+			val, err := nt.GetOut(ch)
+			if err != nil {
+				return nil, err
+			}
+			o.Out = &val
+		}
+		old[ch] = o
+	}
+	return old, nil
+}
+
+// applySetpoints applies sps in a safe order: outputs are switched off
+// first, the master output before the channels. Limits are applied
+// afterwards, and outputs are switched on last, the master output last.
+// Channel 0 refers to the master output.
+func applySetpoints(nt Netzteil, sps map[int]channelSetpoints) error {
+	var channels []int
+	for ch := range sps {
+		if ch != MasterChannel {
+			channels = append(channels, ch)
+		}
+	}
+	sort.Ints(channels)
+
+	if sp, ok := sps[MasterChannel]; ok && sp.Out != nil && !*sp.Out {
+		if err := nt.SetMaster(false); err != nil {
+			return err
+		}
+	}
+	for _, ch := range channels {
+		if sp := sps[ch]; sp.Out != nil && !*sp.Out {
+			if err := nt.SetOut(ch, false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ch := range channels {
+		sp := sps[ch]
+		if sp.Voltage != nil {
+			if err := nt.SetVoltage(ch, *sp.Voltage); err != nil {
+				return err
+			}
+		}
+		if sp.Current != nil {
+			if err := nt.SetCurrent(ch, *sp.Current); err != nil {
+				return err
+			}
+		}
+		if sp.OCP != nil {
+			if err := nt.SetOCP(ch, *sp.OCP); err != nil {
+				return err
+			}
+		}
+		if sp.OVP != nil {
+			if err := nt.SetOVP(ch, *sp.OVP); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ch := range channels {
+		if sp := sps[ch]; sp.Out != nil && *sp.Out {
+			if err := nt.SetOut(ch, true); err != nil {
+				return err
+			}
+		}
+	}
+	if sp, ok := sps[MasterChannel]; ok && sp.Out != nil && *sp.Out {
+		if err := nt.SetMaster(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update applies sps atomically. If a setting fails, the
+// previous values are restored on a best effort basis.
+func (s *HTTPServer) update(d *Device, nt Netzteil, id string, sps map[int]channelSetpoints) error {
+	nChannels, err := nt.GetChannels()
+	if err != nil {
+		return err
+	}
+	for ch := range sps {
+		if ch == MasterChannel {
+			continue
+		}
+		if err := CheckChannel(ch, nChannels); err != nil {
+			return err
+		}
+	}
+	old, err := d.snapshotSetpoints(nt, sps)
+	if err != nil {
+		return err
+	}
+	if err := applySetpoints(nt, sps); err != nil {
+		if rbErr := applySetpoints(nt, old); rbErr != nil {
+			s.Logger.LogErrorf("device %s: rollback failed: %s", id, rbErr)
+			return fmt.Errorf("%w; rollback failed: %s", err, rbErr)
+		}
+		s.Logger.LogWarningf("device %s: settings rolled back: %s", id, err)
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPServer) putChannel(w http.ResponseWriter, r *http.Request) {
+	var (
+		req   channelSetpoints
+		state channelState
+		vars  = mux.Vars(r)
+	)
+	d, id, err := s.lookupDeviceEntry(w, vars)
+	if err != nil {
+		return
+	}
+	channel, err := parseChannel(vars)
+	if err == nil && channel == MasterChannel {
+		err = fmt.Errorf("%w: '%d'", ErrInvalidChannel, channel)
+	}
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = d.transaction(requestActor(r), func(nt Netzteil) error {
+		if err := s.update(d, nt, id, map[int]channelSetpoints{channel: req}); err != nil {
+			return err
+		}
+		state, err = d.readChannelState(nt, channel)
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, state)
+}
+
+func (s *HTTPServer) putChannels(w http.ResponseWriter, r *http.Request) {
+	var (
+		req   deviceSettings
+		state deviceState
+	)
+	d, id, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sps := make(map[int]channelSetpoints)
+	for ch, sp := range req.Channels {
+		// The master output is set via the "out" key.
+		if ch == MasterChannel {
+			sendError(w, fmt.Errorf("%w: '%d'", ErrInvalidChannel, ch))
+			return
+		}
+		sps[ch] = sp
+	}
+	if req.Out != nil {
+		sps[MasterChannel] = channelSetpoints{Out: req.Out}
+	}
+	err = d.transaction(requestActor(r), func(nt Netzteil) error {
+		if err := s.update(d, nt, id, sps); err != nil {
+			return err
+		}
+		state, err = d.readDeviceState(nt)
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, state)
+}
+
+func (s *HTTPServer) getChannelState(w http.ResponseWriter, r *http.Request) {
+	var (
+		state channelState
+		vars  = mux.Vars(r)
+	)
+	d, _, err := s.lookupDeviceEntry(w, vars)
+	if err != nil {
+		return
+	}
+	channel, err := parseChannel(vars)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	err = d.transaction(requestActor(r), func(nt Netzteil) error {
+		nChannels, err := nt.GetChannels()
+		if err != nil {
+			return err
+		}
+		if err := CheckChannel(channel, nChannels); err != nil {
+			return err
+		}
+		state, err = d.readChannelState(nt, channel)
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, state)
+}
+
+func (s *HTTPServer) getDeviceState(w http.ResponseWriter, r *http.Request) {
+	var state deviceState
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	err = d.transaction(requestActor(r), func(nt Netzteil) error {
+		state, err = d.readDeviceState(nt)
+		return err
+	})
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+	helpers.SendJSON(w, state)
+}
@@ -0,0 +1,170 @@
+package opennetzteil
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rumpelsepp/helpers"
+)
+
+// timerStep is a step of the timer mode as sent via the API.
+type timerStep struct {
+	Voltage float64 `json:"voltage"`
+	Current float64 `json:"current"`
+	// Duration is the duration in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// tracker returns the driver of d if it supports tracking.
+func (d *Device) tracker() (Tracker, error) {
+	drv, err := d.driver()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := drv.(Tracker)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return t, nil
+}
+
+// timer returns the driver of d if it supports the timer mode.
+func (d *Device) timer() (Timer, error) {
+	drv, err := d.driver()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := drv.(Timer)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return t, nil
+}
+
+func (d *Device) setTracking(a actor, mode string) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		t, err := d.tracker()
+		if err != nil {
+			return err
+		}
+		err = t.SetTracking(mode)
+		d.audit(a, "SetTracking", MasterChannel, nil, mode, err)
+		return err
+	})
+}
+
+func (d *Device) setTimer(a actor, channel int, steps []TimerStep) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		t, err := d.timer()
+		if err != nil {
+			return err
+		}
+		err = t.SetTimer(channel, steps)
+		d.audit(a, "SetTimer", channel, nil, steps, err)
+		return err
+	})
+}
+
+func (d *Device) enableTimer(a actor, channel int, enabled bool) error {
+	return d.transaction(a, func(nt Netzteil) error {
+		t, err := d.timer()
+		if err != nil {
+			return err
+		}
+		err = t.EnableTimer(channel, enabled)
+		d.audit(a, "EnableTimer", channel, nil, enabled, err)
+		return err
+	})
+}
+
+func (s *HTTPServer) putTracking(w http.ResponseWriter, r *http.Request) {
+	var req string
+	d, _, err := s.lookupDeviceEntry(w, mux.Vars(r))
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.setTracking(requestActor(r), req); err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+// lookupDeviceChannel is like lookupDevAndParseChannel
+// but returns the device entry.
+func (s *HTTPServer) lookupDeviceChannel(w http.ResponseWriter, r *http.Request) (*Device, int, error) {
+	vars := mux.Vars(r)
+	d, _, err := s.lookupDeviceEntry(w, vars)
+	if err != nil {
+		return nil, 0, err
+	}
+	channel, err := parseChannel(vars)
+	if err != nil {
+		sendError(w, err)
+		return nil, 0, err
+	}
+	dev, err := d.driver()
+	if err != nil {
+		sendError(w, err)
+		return nil, 0, err
+	}
+	nChannels, err := dev.GetChannels()
+	if err != nil {
+		sendError(w, err)
+		return nil, 0, err
+	}
+	if err := CheckChannel(channel, nChannels); err != nil {
+		sendError(w, err)
+		return nil, 0, err
+	}
+	return d, channel, nil
+}
+
+func (s *HTTPServer) putTimer(w http.ResponseWriter, r *http.Request) {
+	var req bool
+	d, channel, err := s.lookupDeviceChannel(w, r)
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.enableTimer(requestActor(r), channel, req); err != nil {
+		sendError(w, err)
+		return
+	}
+}
+
+func (s *HTTPServer) putTimerSteps(w http.ResponseWriter, r *http.Request) {
+	var req []timerStep
+	d, channel, err := s.lookupDeviceChannel(w, r)
+	if err != nil {
+		return
+	}
+	if err := helpers.RecvJSON(r, &req); err != nil {
+		sendErrorStatus(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	steps := make([]TimerStep, 0, len(req))
+	for _, step := range req {
+		if step.Duration < 0 {
+			sendError(w, fmt.Errorf("%w: duration %g s; must not be negative", ErrOutOfRange, step.Duration))
+			return
+		}
+		steps = append(steps, TimerStep{
+			Voltage:  step.Voltage,
+			Current:  step.Current,
+			Duration: time.Duration(step.Duration * float64(time.Second)),
+		})
+	}
+	if err := d.setTimer(requestActor(r), channel, steps); err != nil {
+		sendError(w, err)
+		return
+	}
+}
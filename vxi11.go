@@ -0,0 +1,355 @@
+package opennetzteil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ONC-RPC (RFC 5531) and VXI-11 constants.
+const (
+	rpcVersion     = 2
+	rpcCall        = 0
+	rpcReply       = 1
+	rpcLastFrag    = 1 << 31
+	portmapPort    = 111
+	portmapProgram = 100000
+	portmapVersion = 2
+	portmapGetPort = 3
+	ipProtoTCP     = 6
+
+	vxi11Program     = 0x0607af
+	vxi11Version     = 1
+	vxi11CreateLink  = 10
+	vxi11DeviceWrite = 11
+	vxi11DeviceRead  = 12
+	vxi11DestroyLink = 23
+
+	vxi11FlagEnd   = 0x08
+	vxi11ReasonEnd = 0x04
+	// The reason is set to "term char" if the
+	// transfer was terminated by the term char.
+	vxi11ReasonChr = 0x02
+
+	vxi11ErrLocked    = 11
+	vxi11ErrIOTimeout = 15
+)
+
+type xdrWriter struct {
+	bytes.Buffer
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	w.Write(buf[:])
+}
+
+func (w *xdrWriter) opaque(data []byte) {
+	w.uint32(uint32(len(data)))
+	w.Write(data)
+	if pad := len(data) % 4; pad != 0 {
+		w.Write(make([]byte, 4-pad))
+	}
+}
+
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *xdrReader) opaque() []byte {
+	n := int(r.uint32())
+	if r.err != nil {
+		return nil
+	}
+	padded := n
+	if pad := n % 4; pad != 0 {
+		padded += 4 - pad
+	}
+	if len(r.buf) < padded {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	data := r.buf[:n]
+	r.buf = r.buf[padded:]
+	return data
+}
+
+// rpcClient is a minimal ONC-RPC client over TCP without authentication.
+type rpcClient struct {
+	conn    net.Conn
+	xid     uint32
+	prog    uint32
+	vers    uint32
+	timeout time.Duration
+}
+
+func (c *rpcClient) call(proc uint32, args []byte) (*xdrReader, error) {
+	c.xid++
+
+	var msg xdrWriter
+	msg.uint32(0) // record mark, set below
+	msg.uint32(c.xid)
+	msg.uint32(rpcCall)
+	msg.uint32(rpcVersion)
+	msg.uint32(c.prog)
+	msg.uint32(c.vers)
+	msg.uint32(proc)
+	msg.uint32(0) // credentials: AUTH_NONE
+	msg.uint32(0)
+	msg.uint32(0) // verifier: AUTH_NONE
+	msg.uint32(0)
+	msg.Write(args)
+	bs := msg.Bytes()
+	binary.BigEndian.PutUint32(bs, rpcLastFrag|uint32(len(bs)-4))
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, TransportError(err)
+	}
+	if _, err := c.conn.Write(bs); err != nil {
+		return nil, TransportError(err)
+	}
+
+	var reply []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return nil, TransportError(err)
+		}
+		mark := binary.BigEndian.Uint32(header[:])
+		frag := make([]byte, mark&^rpcLastFrag)
+		if _, err := io.ReadFull(c.conn, frag); err != nil {
+			return nil, TransportError(err)
+		}
+		reply = append(reply, frag...)
+		if mark&rpcLastFrag != 0 {
+			break
+		}
+	}
+
+	r := &xdrReader{buf: reply}
+	var (
+		xid       = r.uint32()
+		msgType   = r.uint32()
+		replyStat = r.uint32()
+	)
+	switch {
+	case r.err != nil:
+		return nil, TransportError(r.err)
+	case xid != c.xid || msgType != rpcReply:
+		return nil, fmt.Errorf("%w: rpc: unexpected reply", ErrTransport)
+	case replyStat != 0:
+		return nil, fmt.Errorf("%w: rpc: call denied", ErrTransport)
+	}
+	r.uint32() // verifier
+	r.opaque()
+	if acceptStat := r.uint32(); r.err == nil && acceptStat != 0 {
+		return nil, fmt.Errorf("%w: rpc: call failed: %d", ErrTransport, acceptStat)
+	}
+	if r.err != nil {
+		return nil, TransportError(r.err)
+	}
+	return r, nil
+}
+
+func dialRPC(host string, port uint32, prog, vers uint32, timeout time.Duration) (*rpcClient, error) {
+	target := net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10))
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return nil, TransportError(err)
+	}
+	return &rpcClient{conn: conn, prog: prog, vers: vers, timeout: timeout}, nil
+}
+
+// getPort asks the portmapper of host for the TCP port of prog.
+func getPort(host string, prog, vers uint32, timeout time.Duration) (uint32, error) {
+	c, err := dialRPC(host, portmapPort, portmapProgram, portmapVersion, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer c.conn.Close()
+
+	var args xdrWriter
+	args.uint32(prog)
+	args.uint32(vers)
+	args.uint32(ipProtoTCP)
+	args.uint32(0)
+	r, err := c.call(portmapGetPort, args.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	res := r.uint32()
+	if r.err != nil {
+		return 0, TransportError(r.err)
+	}
+	if res == 0 {
+		return 0, fmt.Errorf("%w: program %#x not registered", ErrTransport, prog)
+	}
+	return res, nil
+}
+
+// VXI11Client is a client of the VXI-11 core channel. It implements
+// io.ReadWriteCloser; each Write() is sent as a complete message,
+// Read() returns the response of the device.
+type VXI11Client struct {
+	rpc     *rpcClient
+	lid     uint32
+	maxRecv uint32
+	timeout time.Duration
+	buf     []byte
+}
+
+// DialVXI11 creates a link to device, e.g. "inst0" or "gpib0,5", on host.
+// timeout is used for the I/O of the device and for the connection.
+func DialVXI11(host, device string, timeout time.Duration) (*VXI11Client, error) {
+	port, err := getPort(host, vxi11Program, vxi11Version, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// The device has its own timeout; give it time to respond.
+	c, err := dialRPC(host, port, vxi11Program, vxi11Version, timeout+time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var args xdrWriter
+	args.uint32(rand.Uint32() & 0x7fffffff) // client id
+	args.uint32(0)                          // lock device
+	args.uint32(0)                          // lock timeout
+	args.opaque([]byte(device))
+	r, err := c.call(vxi11CreateLink, args.Bytes())
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	var (
+		errCode = r.uint32()
+		lid     = r.uint32()
+		_       = r.uint32() // abort port
+		maxRecv = r.uint32()
+	)
+	if err := vxi11Error(errCode, r.err); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return &VXI11Client{rpc: c, lid: lid, maxRecv: maxRecv, timeout: timeout}, nil
+}
+
+func vxi11Error(code uint32, err error) error {
+	switch {
+	case err != nil:
+		return TransportError(err)
+	case code == 0:
+		return nil
+	case code == vxi11ErrLocked:
+		return fmt.Errorf("%w: vxi-11: device locked by another link", ErrBusy)
+	case code == vxi11ErrIOTimeout:
+		return fmt.Errorf("%w: vxi-11: I/O timeout", ErrTimeout)
+	}
+	return fmt.Errorf("%w: vxi-11 error %d", ErrTransport, code)
+}
+
+func (c *VXI11Client) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		var (
+			chunk = p[n:]
+			flags uint32
+		)
+		if c.maxRecv > 0 && len(chunk) > int(c.maxRecv) {
+			chunk = chunk[:c.maxRecv]
+		}
+		if n+len(chunk) == len(p) {
+			flags = vxi11FlagEnd
+		}
+
+		var args xdrWriter
+		args.uint32(c.lid)
+		args.uint32(uint32(c.timeout.Milliseconds()))
+		args.uint32(0) // lock timeout
+		args.uint32(flags)
+		args.opaque(chunk)
+		r, err := c.rpc.call(vxi11DeviceWrite, args.Bytes())
+		if err != nil {
+			return n, err
+		}
+		var (
+			errCode = r.uint32()
+			size    = r.uint32()
+		)
+		if err := vxi11Error(errCode, r.err); err != nil {
+			return n, err
+		}
+		if size == 0 {
+			return n, io.ErrShortWrite
+		}
+		n += int(size)
+	}
+	return n, nil
+}
+
+// Read reads the response of the device. A complete
+// response is buffered until it was read entirely.
+func (c *VXI11Client) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var args xdrWriter
+		args.uint32(c.lid)
+		args.uint32(64 * 1024) // request size
+		args.uint32(uint32(c.timeout.Milliseconds()))
+		args.uint32(0) // lock timeout
+		args.uint32(0) // flags
+		args.uint32(0) // term char
+		r, err := c.rpc.call(vxi11DeviceRead, args.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		var (
+			errCode = r.uint32()
+			reason  = r.uint32()
+			data    = r.opaque()
+		)
+		if err := vxi11Error(errCode, r.err); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf, data...)
+		if reason&(vxi11ReasonEnd|vxi11ReasonChr) != 0 {
+			if len(c.buf) == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Close destroys the link and closes the connection.
+func (c *VXI11Client) Close() error {
+	var args xdrWriter
+	args.uint32(c.lid)
+	_, err := c.rpc.call(vxi11DestroyLink, args.Bytes())
+	if cerr := c.rpc.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}